@@ -3,15 +3,33 @@ package main
 
 import (
 	"go-live-markdown/internal/host"
+	"go-live-markdown/internal/logging"
 	"log"
+	"log/slog"
+	"os"
 
 	"github.com/neovim/go-client/nvim/plugin"
 )
 
-// main registers plugin handlers and starts the Neovim host loop.
+// main registers plugin handlers and starts the Neovim host loop. Invoked
+// with the daemon subcommand it runs the shared preview daemon instead.
+// Both log to $NVIM_GO_LIVE_MARKDOWN_LOG_FILE when it is set.
 func main() {
+	closeLog, err := logging.Setup()
+	if err != nil {
+		log.Printf("[go-live-markdown] %v", err)
+	}
+	defer closeLog()
+
+	if len(os.Args) > 1 && os.Args[1] == host.DaemonCommand {
+		if err := host.RunDaemon(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	plugin.Main(func(p *plugin.Plugin) error {
-		log.Println("[go-live-markdown] registering handlers")
+		slog.Info("registering handlers")
 		return host.Register(p)
 	})
 }
@@ -0,0 +1,26 @@
+package app
+
+import (
+	"errors"
+
+	"go-live-markdown/internal/render"
+	httpserver "go-live-markdown/internal/transport/http"
+)
+
+// RunDaemon serves the shared preview daemon on the control socket until the
+// last editor host disconnects. It returns immediately when another daemon
+// already answers on socket.
+func RunDaemon(socket string, cfg ListenConfig) error {
+	ctl, err := httpserver.ListenControl(socket)
+	if errors.Is(err, httpserver.ErrDaemonRunning) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer ctl.Close()
+
+	renderer := render.NewRenderer()
+	preview := httpserver.NewPreviewServer(cfg, renderer.RenderShell())
+	return httpserver.NewDaemonServer(preview).Serve(ctl)
+}
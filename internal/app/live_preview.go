@@ -2,6 +2,9 @@
 package app
 
 import (
+	"sync"
+	"time"
+
 	"go-live-markdown/internal/contracts"
 	"go-live-markdown/internal/render"
 	httpserver "go-live-markdown/internal/transport/http"
@@ -10,29 +13,181 @@ import (
 // LivePreview is a coordinator between markdown rendering and HTTP delivery.
 type LivePreview struct {
 	renderer *render.Renderer
-	preview  *httpserver.PreviewServer
+	local    *httpserver.PreviewServer
+
+	mu      sync.Mutex
+	preview previewTransport
+
+	onGoToLine       func(string, contracts.GoToLineMessage)
+	onToggleCheckbox func(string, contracts.ToggleCheckboxMessage)
+
+	// publishMu orders publishing against closing sessions.
+	publishMu sync.Mutex
+
+	// revMu guards source revisions. Every published or queued source gets
+	// a new revision; a rendered revision is dropped when a newer one of
+	// its session was published already, or when it predates the floor set
+	// by the last Stop.
+	revMu     sync.Mutex
+	rev       uint64
+	floor     uint64
+	published map[string]uint64
+	pending   map[string]renderJob
+	wake      chan struct{}
+
+	// includes maps the include handles of the latest render published to
+	// each session to the included files. Browsers only name included
+	// files by these handles.
+	includes map[string]map[string]string
+	// renders holds how the latest render of each session went.
+	renders map[string]renderStats
+}
+
+// renderStats describes the latest render of a session.
+type renderStats struct {
+	duration time.Duration
+	// err is why the latest render failed; it is cleared by the next one
+	// that succeeds.
+	err string
+}
+
+// previewTransport delivers rendered sessions to browsers, either from the
+// in-process preview server or through the shared preview daemon.
+type previewTransport interface {
+	URL() string
+	SessionURL(session string) string
+	Running() bool
+	StartOrUpdate(session string, blocks []contracts.Block, toc []contracts.TOCItem, path string, assets map[string]string) error
+	UpdateCursor(session string, msg contracts.CursorMessage) error
+	CloseSession(session string)
+	Stop() error
+	Share(session string) (string, error)
+	Unshare(session string)
+	Viewers(session string) []httpserver.Viewer
+	SetGoToLineHandler(fn func(string, contracts.GoToLineMessage))
+	SetToggleCheckboxHandler(fn func(string, contracts.ToggleCheckboxMessage))
+}
+
+// ListenConfig selects the address the preview server binds to.
+type ListenConfig = httpserver.ListenConfig
+
+// ShareConfig selects the interface shared sessions are served on.
+type ShareConfig = httpserver.ShareConfig
+
+// Viewer is a read-only browser connected to a shared session.
+type Viewer = httpserver.Viewer
+
+// Metrics holds render durations and the sizes of messages sent to browsers.
+type Metrics = httpserver.Metrics
+
+// DebugState is a snapshot of the preview server for troubleshooting.
+type DebugState = httpserver.DebugState
+
+// Options selects how and where previews are served.
+type Options struct {
+	Listen ListenConfig
+	// DaemonSocket, when set, publishes sessions to the shared preview
+	// daemon listening on this control socket instead of serving them
+	// in-process.
+	DaemonSocket string
+	// SpawnDaemon launches the daemon when none listens on DaemonSocket.
+	SpawnDaemon func() error
+	// AssetRoots are directories local images may be loaded from in
+	// addition to each document's directory and project root.
+	AssetRoots []string
+	// FenceRenderers maps fence languages to commands that print the SVG
+	// shown in place of a fence, such as "dot -Tsvg".
+	FenceRenderers map[string]string
+	// Share selects where sessions are shared with read-only viewers.
+	Share ShareConfig
 }
 
 // NewLivePreview wires the markdown renderer with the HTTP preview transport.
-func NewLivePreview(addr string) *LivePreview {
+func NewLivePreview(cfg ListenConfig) *LivePreview {
 	renderer := render.NewRenderer()
-	return &LivePreview{
-		renderer: renderer,
-		preview:  httpserver.NewPreviewServer(addr, renderer.RenderShell()),
+	local := httpserver.NewPreviewServer(cfg, renderer.RenderShell())
+	s := &LivePreview{
+		renderer:  renderer,
+		local:     local,
+		preview:   local,
+		published: make(map[string]uint64),
+		pending:   make(map[string]renderJob),
+		includes:  make(map[string]map[string]string),
+		renders:   make(map[string]renderStats),
+		wake:      make(chan struct{}, 1),
+	}
+
+	go s.renderLoop()
+	return s
+}
+
+// Configure applies the asset roots, fence renderers and share options
+// and selects the transport used by the next preview start. The transport
+// is kept while previews are being served.
+func (s *LivePreview) Configure(opts Options) {
+	s.renderer.SetAssetRoots(opts.AssetRoots)
+	s.renderer.SetFenceRenderers(opts.FenceRenderers)
+	s.local.SetShareConfig(opts.Share)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.preview.Running() {
+		return
+	}
+
+	if opts.DaemonSocket == "" {
+		s.local.SetListenConfig(opts.Listen)
+		s.preview = s.local
+		return
 	}
+
+	daemon := httpserver.NewDaemonClient(opts.DaemonSocket, opts.SpawnDaemon)
+	daemon.SetGoToLineHandler(s.onGoToLine)
+	daemon.SetToggleCheckboxHandler(s.onToggleCheckbox)
+	s.preview = daemon
+}
+
+// transport returns the active preview transport.
+func (s *LivePreview) transport() previewTransport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.preview
 }
 
 // URL returns the preview server URL that users can open in a browser.
+// When the preferred port was busy it reports the port actually bound.
 func (s *LivePreview) URL() string {
-	return s.preview.URL()
+	return s.transport().URL()
+}
+
+// SessionURL returns the browser URL of a single preview session.
+func (s *LivePreview) SessionURL(session string) string {
+	return s.transport().SessionURL(session)
 }
 
-// PublishSource renders markdown source and publishes it to the preview server.
-func (s *LivePreview) PublishSource(source []byte, path string) error {
+// PublishSource renders markdown source and publishes it to a preview
+// session before returning. Use QueueSource for edits.
+func (s *LivePreview) PublishSource(session string, source []byte, path string) error {
+	s.revMu.Lock()
+	rev := s.nextRevLocked()
+	delete(s.pending, session)
+	s.revMu.Unlock()
+
+	return s.render(session, source, path, rev)
+}
+
+// render converts a source revision and publishes it unless it is stale.
+func (s *LivePreview) render(session string, source []byte, path string, rev uint64) error {
+	started := time.Now()
 	doc, err := s.renderer.ConvertDocumentWithSourcePath(source, path)
 	if err != nil {
+		s.recordRender(session, 0, err)
 		return err
 	}
+	elapsed := time.Since(started)
+	s.local.RecordRender(elapsed)
 
 	toc := make([]contracts.TOCItem, 0, len(doc.TOC))
 	for _, item := range doc.TOC {
@@ -44,24 +199,194 @@ func (s *LivePreview) PublishSource(source []byte, path string) error {
 		})
 	}
 
-	return s.preview.StartOrUpdate(doc.HTML, toc, path)
+	blocks := make([]contracts.Block, 0, len(doc.Blocks))
+	for _, block := range doc.Blocks {
+		blocks = append(blocks, contracts.Block{
+			Key:  block.Key,
+			Line: block.Line,
+			HTML: block.HTML,
+		})
+	}
+
+	s.publishMu.Lock()
+	defer s.publishMu.Unlock()
+
+	s.revMu.Lock()
+	current := s.currentLocked(session, rev)
+	if current {
+		s.published[session] = rev
+		s.includes[session] = doc.Includes
+	}
+	s.revMu.Unlock()
+
+	if !current {
+		return nil
+	}
+	err = s.transport().StartOrUpdate(session, blocks, toc, path, doc.Assets)
+	s.recordRender(session, elapsed, err)
+	return err
+}
+
+// recordRender remembers how a render of session went, for SessionStatus.
+func (s *LivePreview) recordRender(session string, elapsed time.Duration, err error) {
+	s.revMu.Lock()
+	defer s.revMu.Unlock()
+
+	stats := s.renders[session]
+	if err != nil {
+		stats.err = err.Error()
+	} else {
+		stats = renderStats{duration: elapsed}
+	}
+	s.renders[session] = stats
 }
 
-// PublishCursor forwards the current editor cursor position to the browser.
-func (s *LivePreview) PublishCursor(line int, col int) error {
-	return s.preview.UpdateCursor(contracts.CursorMessage{
+// PublishCursor forwards the current editor cursor position to a session's browser.
+func (s *LivePreview) PublishCursor(session string, line int, col int) error {
+	return s.transport().UpdateCursor(session, contracts.CursorMessage{
 		Type: contracts.MessageTypeCursor,
 		Line: line,
 		Col:  col,
 	})
 }
 
+// CloseSession removes a preview session and disconnects its browser.
+func (s *LivePreview) CloseSession(session string) {
+	s.publishMu.Lock()
+	defer s.publishMu.Unlock()
+
+	s.revMu.Lock()
+	delete(s.pending, session)
+	delete(s.includes, session)
+	delete(s.renders, session)
+	s.published[session] = s.rev
+	s.revMu.Unlock()
+
+	s.transport().CloseSession(session)
+}
+
+// IncludedFile returns the path of the file the latest render published to
+// session included under handle.
+func (s *LivePreview) IncludedFile(session string, handle string) (string, bool) {
+	s.revMu.Lock()
+	defer s.revMu.Unlock()
+
+	path, ok := s.includes[session][handle]
+	return path, ok
+}
+
+// Share grants read-only access to a session and returns the URL viewers
+// on the local network open.
+func (s *LivePreview) Share(session string) (string, error) {
+	return s.transport().Share(session)
+}
+
+// Unshare revokes viewer access to a session and disconnects its viewers.
+func (s *LivePreview) Unshare(session string) {
+	s.transport().Unshare(session)
+}
+
+// Viewers lists the read-only browsers connected to a session.
+func (s *LivePreview) Viewers(session string) []Viewer {
+	return s.transport().Viewers(session)
+}
+
+// Metrics returns the render durations and message sizes recorded so far.
+// Messages sent by the shared daemon are recorded by the daemon itself and
+// served on its /debug/metrics endpoint.
+func (s *LivePreview) Metrics() Metrics {
+	return s.local.Metrics()
+}
+
+// DebugState describes the in-process preview server. When the shared
+// daemon serves the previews, it names the daemon instead.
+func (s *LivePreview) DebugState() DebugState {
+	state := s.local.DebugState()
+	if daemon, ok := s.transport().(*httpserver.DaemonClient); ok {
+		state.Daemon = daemon.URL()
+	}
+	return state
+}
+
+// SessionStatus summarizes a preview session for statuslines.
+type SessionStatus struct {
+	URL string
+	// Browsers counts the connected browsers, Viewers the read-only ones
+	// among them.
+	Browsers int
+	Viewers  int
+	// Rev is the revision of the latest render sent to browsers.
+	Rev uint64
+	// RenderMs is how long the latest successful render of the session
+	// took. LastError is why a later render failed, if one did.
+	RenderMs  float64
+	LastError string
+}
+
+// SessionStatus describes a session of the in-process preview server, or
+// asks the shared daemon when it serves the session.
+func (s *LivePreview) SessionStatus(session string) SessionStatus {
+	status := SessionStatus{URL: s.SessionURL(session)}
+
+	s.revMu.Lock()
+	stats := s.renders[session]
+	s.revMu.Unlock()
+	status.RenderMs = float64(stats.duration.Microseconds()) / 1000
+	status.LastError = stats.err
+
+	if daemon, ok := s.transport().(*httpserver.DaemonClient); ok {
+		if info, ok := daemon.Status(session); ok {
+			status.Browsers = info.Browsers
+			status.Viewers = info.Viewers
+			status.Rev = info.Rev
+		}
+		return status
+	}
+
+	info, ok := s.local.SessionDebug(session)
+	if !ok {
+		return status
+	}
+	status.Rev = info.Rev
+	status.Browsers = len(info.Clients)
+	for _, client := range info.Clients {
+		if client.Viewer {
+			status.Viewers++
+		}
+	}
+	return status
+}
+
+// Stop shuts down the preview server and disconnects every browser.
+func (s *LivePreview) Stop() error {
+	s.publishMu.Lock()
+	defer s.publishMu.Unlock()
+
+	s.revMu.Lock()
+	s.floor = s.rev
+	s.published = make(map[string]uint64)
+	s.pending = make(map[string]renderJob)
+	s.includes = make(map[string]map[string]string)
+	s.renders = make(map[string]renderStats)
+	s.revMu.Unlock()
+
+	return s.transport().Stop()
+}
+
 // SetGoToLineHandler registers a callback for browser-initiated go-to-line events.
-func (s *LivePreview) SetGoToLineHandler(fn func(contracts.GoToLineMessage)) {
+func (s *LivePreview) SetGoToLineHandler(fn func(string, contracts.GoToLineMessage)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onGoToLine = fn
 	s.preview.SetGoToLineHandler(fn)
 }
 
 // SetToggleCheckboxHandler registers a callback for browser-initiated checkbox toggles.
-func (s *LivePreview) SetToggleCheckboxHandler(fn func(contracts.ToggleCheckboxMessage)) {
+func (s *LivePreview) SetToggleCheckboxHandler(fn func(string, contracts.ToggleCheckboxMessage)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onToggleCheckbox = fn
 	s.preview.SetToggleCheckboxHandler(fn)
 }
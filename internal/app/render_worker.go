@@ -0,0 +1,109 @@
+package app
+
+import (
+	"log/slog"
+	"time"
+)
+
+const (
+	// renderDebounce is how long the render worker waits after the latest
+	// edit before rendering. Every new edit restarts the wait, so a burst of
+	// edits is rendered once.
+	renderDebounce = 20 * time.Millisecond
+
+	// renderMaxDelay bounds how long continuous typing can hold a render
+	// back.
+	renderMaxDelay = 150 * time.Millisecond
+)
+
+// renderJob is a source revision waiting to be rendered.
+type renderJob struct {
+	session string
+	source  []byte
+	path    string
+	rev     uint64
+}
+
+// QueueSource schedules markdown source for rendering on the background
+// worker and returns immediately. Only the latest source of a session is
+// kept, so revisions superseded before the worker picks them up are never
+// rendered.
+func (s *LivePreview) QueueSource(session string, source []byte, path string) {
+	s.revMu.Lock()
+	s.pending[session] = renderJob{
+		session: session,
+		source:  source,
+		path:    path,
+		rev:     s.nextRevLocked(),
+	}
+	s.revMu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// renderLoop renders queued sources one batch at a time once edits have
+// settled for renderDebounce, or renderMaxDelay after the first unrendered
+// edit. Sources queued while a batch renders are picked up by the next one.
+func (s *LivePreview) renderLoop() {
+	timer := time.NewTimer(renderDebounce)
+	timer.Stop()
+
+	var first time.Time
+	for {
+		select {
+		case <-s.wake:
+			now := time.Now()
+			if first.IsZero() {
+				first = now
+			}
+			wait := renderDebounce
+			if left := first.Add(renderMaxDelay).Sub(now); left < wait {
+				wait = max(left, 0)
+			}
+			timer.Reset(wait)
+
+		case <-timer.C:
+			first = time.Time{}
+			s.renderPending()
+		}
+	}
+}
+
+// renderPending renders every queued job.
+func (s *LivePreview) renderPending() {
+	for _, job := range s.takePending() {
+		if err := s.render(job.session, job.source, job.path, job.rev); err != nil {
+			slog.Error("render failed", "session", job.session, "path", job.path, "err", err)
+		}
+	}
+}
+
+// takePending removes and returns every queued job.
+func (s *LivePreview) takePending() []renderJob {
+	s.revMu.Lock()
+	defer s.revMu.Unlock()
+
+	jobs := make([]renderJob, 0, len(s.pending))
+	for session, job := range s.pending {
+		jobs = append(jobs, job)
+		delete(s.pending, session)
+	}
+	return jobs
+}
+
+// nextRevLocked hands out the next source revision. The caller must hold
+// s.revMu.
+func (s *LivePreview) nextRevLocked() uint64 {
+	s.rev++
+	return s.rev
+}
+
+// current reports whether rev is newer than anything published to session
+// and was issued after the session was last closed. The caller must hold
+// s.revMu.
+func (s *LivePreview) currentLocked(session string, rev uint64) bool {
+	return rev > s.floor && rev > s.published[session]
+}
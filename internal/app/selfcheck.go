@@ -0,0 +1,182 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"go-live-markdown/internal/contracts"
+	"go-live-markdown/internal/render"
+	httpserver "go-live-markdown/internal/transport/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// selfCheckTimeout bounds the websocket round trip of the self-check.
+const selfCheckTimeout = 3 * time.Second
+
+// Check statuses, matching the levels of Neovim's :checkhealth.
+const (
+	CheckOK    = "ok"
+	CheckWarn  = "warn"
+	CheckError = "error"
+)
+
+// Check is the outcome of a single self-check.
+type Check struct {
+	Name    string
+	Status  string
+	Message string
+}
+
+// SelfCheck verifies that previews can be served: that the configured
+// address can be bound, that a browser message round-trips through a
+// preview server over a loopback websocket, and that sample, a markdown
+// file, renders. The running preview itself is left alone.
+func (s *LivePreview) SelfCheck(listen ListenConfig, sample string) []Check {
+	return []Check{
+		s.checkListen(listen),
+		checkWebsocket(listen.Host),
+		checkRender(sample),
+		checkVendor(),
+	}
+}
+
+func (s *LivePreview) checkListen(listen ListenConfig) Check {
+	check := Check{Name: "listen address"}
+
+	if s.transport().Running() {
+		check.Status = CheckOK
+		check.Message = "preview is being served at " + s.URL()
+		return check
+	}
+
+	addr, err := httpserver.CheckListen(listen)
+	switch {
+	case err != nil:
+		check.Status = CheckError
+		check.Message = err.Error()
+	case addr != listen.Address():
+		check.Status = CheckWarn
+		check.Message = fmt.Sprintf("%s is busy; previews would be served on %s", listen.Address(), addr)
+	default:
+		check.Status = CheckOK
+		check.Message = "can bind " + addr
+	}
+	return check
+}
+
+// checkWebsocket serves a document from a throwaway preview server on an
+// ephemeral port of host, reads it back over a websocket and sends a
+// go-to-line message the other way.
+func checkWebsocket(host string) Check {
+	check := Check{Name: "websocket round trip"}
+	if err := websocketRoundTrip(host); err != nil {
+		check.Status = CheckError
+		check.Message = err.Error()
+		return check
+	}
+	check.Status = CheckOK
+	check.Message = "render and go-to-line messages went through"
+	return check
+}
+
+func websocketRoundTrip(host string) error {
+	server := httpserver.NewPreviewServer(ListenConfig{Host: host}, "")
+	defer func() {
+		_ = server.Stop()
+	}()
+
+	const session = "self-check"
+	jumped := make(chan int, 1)
+	server.SetGoToLineHandler(func(_ string, msg contracts.GoToLineMessage) {
+		jumped <- msg.Line
+	})
+
+	block := contracts.Block{Key: "self-check", Line: 1, HTML: `<p data-md-line="1">self-check</p>`}
+	if err := server.StartOrUpdate(session, []contracts.Block{block}, nil, "self-check.md", nil); err != nil {
+		return err
+	}
+
+	page, err := url.Parse(server.SessionURL(session))
+	if err != nil {
+		return err
+	}
+	ws := url.URL{
+		Scheme:   "ws",
+		Host:     page.Host,
+		Path:     "/ws",
+		RawQuery: url.Values{"doc": {session}, "token": {page.Query().Get("token")}}.Encode(),
+	}
+
+	dialer := websocket.Dialer{HandshakeTimeout: selfCheckTimeout}
+	conn, _, err := dialer.Dial(ws.String(), nil)
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", page.Host, err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(selfCheckTimeout))
+
+	var msg contracts.RenderMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		return fmt.Errorf("reading the render: %w", err)
+	}
+	if msg.Type != contracts.MessageTypeRender || !strings.Contains(msg.HTML, "self-check") {
+		return fmt.Errorf("unexpected %q message instead of the render", msg.Type)
+	}
+
+	if err := conn.WriteJSON(contracts.GoToLineMessage{Type: contracts.MessageTypeGoToLine, Line: 1}); err != nil {
+		return fmt.Errorf("sending go-to-line: %w", err)
+	}
+	select {
+	case <-jumped:
+		return nil
+	case <-time.After(selfCheckTimeout):
+		return errors.New("go-to-line message did not arrive")
+	}
+}
+
+// checkRender renders the markdown file at path with a fresh renderer.
+// checkVendor reports whether the Mermaid runtime was embedded in the host.
+func checkVendor() Check {
+	check := Check{Name: "mermaid runtime"}
+
+	info, err := fs.Stat(render.Vendor, render.MermaidRuntime)
+	if err != nil {
+		check.Status = CheckWarn
+		check.Message = "not bundled with the host; mermaid diagrams show their source"
+		return check
+	}
+
+	check.Status = CheckOK
+	check.Message = fmt.Sprintf("bundled (%d KiB)", info.Size()/1024)
+	return check
+}
+
+func checkRender(path string) Check {
+	check := Check{Name: "renderer"}
+
+	source, err := os.ReadFile(path)
+	if err != nil {
+		check.Status = CheckWarn
+		check.Message = fmt.Sprintf("cannot read sample document: %v", err)
+		return check
+	}
+
+	started := time.Now()
+	doc, err := render.NewRenderer().ConvertDocumentWithSourcePath(source, path)
+	if err != nil {
+		check.Status = CheckError
+		check.Message = fmt.Sprintf("rendering %s failed: %v", path, err)
+		return check
+	}
+
+	check.Status = CheckOK
+	check.Message = fmt.Sprintf("rendered %s: %d blocks, %d headings in %s",
+		path, len(doc.Blocks), len(doc.TOC), time.Since(started).Round(time.Millisecond))
+	return check
+}
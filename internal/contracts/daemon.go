@@ -0,0 +1,57 @@
+package contracts
+
+const (
+	// ControlTypeHello opens a control connection, naming the client ID of
+	// a previous connection if any; the daemon answers with the client ID
+	// and the URL of the preview index page.
+	ControlTypeHello = "hello"
+	// ControlTypeRender publishes rendered HTML for one of the client's sessions.
+	ControlTypeRender = "render"
+	// ControlTypeCursor publishes a cursor update for one of the client's sessions.
+	ControlTypeCursor = "cursor"
+	// ControlTypeClose removes one of the client's sessions.
+	ControlTypeClose = "close"
+	// ControlTypeGoToLine forwards a browser go-to-line request to the client.
+	ControlTypeGoToLine = "go_to_line"
+	// ControlTypeToggleCheckbox forwards a browser checkbox toggle to the client.
+	ControlTypeToggleCheckbox = "toggle_checkbox"
+	// ControlTypeStatus asks for the browsers and latest render revision of
+	// one of the client's sessions; the daemon answers with the same type.
+	ControlTypeStatus = "status"
+)
+
+// StatusMessage is the daemon's answer to a status request.
+type StatusMessage struct {
+	// Browsers counts the connected browsers, Viewers the read-only ones
+	// among them.
+	Browsers int `json:"browsers"`
+	Viewers  int `json:"viewers"`
+	// Rev is the revision of the latest render sent to browsers.
+	Rev uint64 `json:"rev"`
+}
+
+// ControlMessage is a single message of the protocol spoken between editor
+// hosts and the shared preview daemon over its local control socket.
+// Session IDs are always the client's own IDs; the daemon namespaces them.
+type ControlMessage struct {
+	Type    string `json:"type"`
+	Session string `json:"session,omitempty"`
+
+	// Hello reply.
+	Client string `json:"client,omitempty"`
+	URL    string `json:"url,omitempty"`
+
+	// Render payload. Token is the access token of the session, chosen by
+	// the client so it can print session URLs itself. Assets maps asset
+	// handles used in the blocks to local file paths.
+	Blocks []Block           `json:"blocks,omitempty"`
+	TOC    []TOCItem         `json:"toc,omitempty"`
+	Path   string            `json:"path,omitempty"`
+	Token  string            `json:"token,omitempty"`
+	Assets map[string]string `json:"assets,omitempty"`
+
+	Cursor         *CursorMessage         `json:"cursor,omitempty"`
+	GoToLine       *GoToLineMessage       `json:"go_to_line,omitempty"`
+	ToggleCheckbox *ToggleCheckboxMessage `json:"toggle_checkbox,omitempty"`
+	Status         *StatusMessage         `json:"status,omitempty"`
+}
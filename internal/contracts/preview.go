@@ -4,12 +4,22 @@ package contracts
 const (
 	// MessageTypeRender updates the browser with rendered markdown HTML.
 	MessageTypeRender = "render"
+	// MessageTypePatch updates the rendered HTML block by block.
+	MessageTypePatch = "patch"
+	// MessageTypeResync asks for a full render when a patch does not apply.
+	MessageTypeResync = "resync"
 	// MessageTypeCursor updates the browser cursor/scroll position.
 	MessageTypeCursor = "cursor"
 	// MessageTypeGoToLine asks Neovim to move its cursor to a source line.
 	MessageTypeGoToLine = "go_to_line"
 	// MessageTypeToggleCheckbox asks Neovim to toggle a markdown task checkbox.
 	MessageTypeToggleCheckbox = "toggle_checkbox"
+	// MessageTypeScroll shares the presenter's scroll position.
+	MessageTypeScroll = "scroll"
+	// MessageTypeFold shares the presenter's collapsed sections.
+	MessageTypeFold = "fold"
+	// MessageTypeViewport brings a newly connected browser to the presenter's view.
+	MessageTypeViewport = "viewport"
 )
 
 // IncomingMessage is the minimal envelope used to route browser messages.
@@ -17,10 +27,13 @@ type IncomingMessage struct {
 	Type string `json:"type"`
 }
 
-// GoToLineMessage requests a cursor jump in the editor.
+// GoToLineMessage requests a cursor jump in the editor. Include is set for
+// content included from another file: it is the handle the latest render
+// of the session issued for the file, which is opened at Line.
 type GoToLineMessage struct {
-	Type string `json:"type"`
-	Line int    `json:"line"`
+	Type    string `json:"type"`
+	Line    int    `json:"line"`
+	Include string `json:"include,omitempty"`
 }
 
 // ToggleCheckboxMessage requests a task-list checkbox toggle in the editor.
@@ -38,7 +51,41 @@ type TOCItem struct {
 	Line  int    `json:"line"`
 }
 
+// Block is a top-level block of rendered HTML. Key identifies its content
+// with source lines taken relative to Line, the first line it refers to,
+// so a block that only moved keeps its key. Line is zero for blocks
+// without line metadata.
+type Block struct {
+	Key  string `json:"key"`
+	Line int    `json:"line"`
+	HTML string `json:"html"`
+}
+
+// PatchMessage turns the document the browser rendered at BaseRev into Rev.
+// The blocks in Remove are dropped, Insert is placed after the block with ID
+// After (at the start when After is empty), and the source lines of the
+// blocks following the inserted ones move by Shift.
+type PatchMessage struct {
+	Type     string       `json:"type"`
+	BaseRev  uint64       `json:"base_rev"`
+	Rev      uint64       `json:"rev"`
+	After    string       `json:"after"`
+	Remove   []string     `json:"remove"`
+	Insert   []PatchBlock `json:"insert"`
+	Shift    int          `json:"shift"`
+	TOC      []TOCItem    `json:"toc"`
+	Filename string       `json:"filename"`
+}
+
+// PatchBlock is a block inserted by a patch.
+type PatchBlock struct {
+	ID   string `json:"id"`
+	HTML string `json:"html"`
+}
+
 // RenderMessage carries rendered HTML and revision metadata to the browser.
+// Every top-level block is preceded by a <!--b:ID--> comment naming it for
+// later patches.
 type RenderMessage struct {
 	Type     string    `json:"type"`
 	HTML     string    `json:"html"`
@@ -47,6 +94,31 @@ type RenderMessage struct {
 	Rev      uint64    `json:"rev"`
 }
 
+// ScrollMessage is the presenter's scroll position. It is anchored to the
+// source line at the top of the viewport and the fraction of that block
+// scrolled past, so windows of different sizes show the same content.
+type ScrollMessage struct {
+	Type    string  `json:"type"`
+	Line    int     `json:"line"`
+	Offset  float64 `json:"offset"`
+	Heading string  `json:"heading"`
+	Rev     uint64  `json:"rev"`
+}
+
+// FoldMessage lists the fold keys of the presenter's collapsed sections.
+type FoldMessage struct {
+	Type      string   `json:"type"`
+	Collapsed []string `json:"collapsed"`
+}
+
+// ViewportMessage is the presenter's complete view, sent to browsers that
+// join a session after it was shared.
+type ViewportMessage struct {
+	Type   string         `json:"type"`
+	Scroll *ScrollMessage `json:"scroll,omitempty"`
+	Fold   *FoldMessage   `json:"fold,omitempty"`
+}
+
 // CursorMessage carries cursor position and revision metadata to the browser.
 type CursorMessage struct {
 	Type string `json:"type"`
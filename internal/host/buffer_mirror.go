@@ -0,0 +1,170 @@
+package host
+
+import (
+	"bytes"
+
+	"github.com/neovim/go-client/nvim"
+)
+
+// bufferMirror is the host's copy of a previewed buffer. It is kept current
+// by applying nvim_buf_lines_event deltas, so rendering does not have to read
+// the whole buffer back from Neovim on every change.
+type bufferMirror struct {
+	lines [][]byte
+	tick  int64
+
+	// partial is set while a multipart change is still being received.
+	partial bool
+}
+
+// bufLinesEvent is a decoded nvim_buf_lines_event notification.
+type bufLinesEvent struct {
+	buf nvim.Buffer
+
+	// hasTick is false when Neovim sent v:null, i.e. the change did not
+	// bump b:changedtick.
+	tick    int64
+	hasTick bool
+
+	first int
+	last  int
+	lines [][]byte
+	more  bool
+}
+
+// parseBufLinesEvent decodes the arguments of nvim_buf_lines_event:
+// [buf, changedtick, firstline, lastline, linedata, more].
+func parseBufLinesEvent(args []interface{}) (bufLinesEvent, bool) {
+	var ev bufLinesEvent
+	if len(args) < 6 {
+		return ev, false
+	}
+
+	buf, ok := args[0].(nvim.Buffer)
+	if !ok {
+		return ev, false
+	}
+	ev.buf = buf
+
+	if args[1] != nil {
+		tick, ok := eventInt(args[1])
+		if !ok {
+			return ev, false
+		}
+		ev.tick = tick
+		ev.hasTick = true
+	}
+
+	first, ok := eventInt(args[2])
+	if !ok {
+		return ev, false
+	}
+	last, ok := eventInt(args[3])
+	if !ok {
+		return ev, false
+	}
+	ev.first = int(first)
+	ev.last = int(last)
+
+	data, ok := args[4].([]interface{})
+	if !ok {
+		return ev, false
+	}
+	ev.lines = make([][]byte, len(data))
+	for i, item := range data {
+		switch line := item.(type) {
+		case string:
+			ev.lines[i] = []byte(line)
+		case []byte:
+			ev.lines[i] = line
+		default:
+			return ev, false
+		}
+	}
+
+	ev.more, _ = args[5].(bool)
+	return ev, true
+}
+
+// parseBufTickEvent decodes the buffer and tick of nvim_buf_changedtick_event
+// and nvim_buf_detach_event notifications.
+func parseBufTickEvent(args []interface{}) (nvim.Buffer, int64, bool) {
+	if len(args) < 1 {
+		return 0, 0, false
+	}
+	buf, ok := args[0].(nvim.Buffer)
+	if !ok {
+		return 0, 0, false
+	}
+	if len(args) < 2 {
+		return buf, 0, true
+	}
+	tick, _ := eventInt(args[1])
+	return buf, tick, true
+}
+
+func eventInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case uint64:
+		return int64(n), true
+	case int:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+// reset replaces the mirrored content with a full read of the buffer.
+func (m *bufferMirror) reset(lines [][]byte, tick int64) {
+	m.lines = lines
+	m.tick = tick
+	m.partial = false
+}
+
+// apply splices a lines event into the mirror. It returns false when the
+// event does not directly follow the mirrored changedtick or does not fit
+// the mirrored lines; the mirror must then be resynced from the buffer.
+func (m *bufferMirror) apply(ev bufLinesEvent) bool {
+	if ev.last < 0 {
+		// The whole buffer is sent after (re)attaching with send_buffer.
+		m.reset(ev.lines, ev.tick)
+		m.partial = ev.more
+		return true
+	}
+
+	if ev.hasTick {
+		next := m.tick + 1
+		if m.partial {
+			// Later parts of a multipart change repeat its changedtick.
+			next = m.tick
+		}
+		if ev.tick != next {
+			return false
+		}
+		m.tick = ev.tick
+	}
+	m.partial = ev.more
+
+	if ev.first < 0 || ev.first > ev.last || ev.last > len(m.lines) {
+		return false
+	}
+
+	if len(ev.lines) == ev.last-ev.first {
+		// Plain edits replace the same number of lines; splice in place.
+		copy(m.lines[ev.first:ev.last], ev.lines)
+		return true
+	}
+
+	lines := make([][]byte, 0, len(m.lines)-(ev.last-ev.first)+len(ev.lines))
+	lines = append(lines, m.lines[:ev.first]...)
+	lines = append(lines, ev.lines...)
+	lines = append(lines, m.lines[ev.last:]...)
+	m.lines = lines
+	return true
+}
+
+// source returns the mirrored buffer contents as markdown source.
+func (m *bufferMirror) source() []byte {
+	return bytes.Join(m.lines, []byte("\n"))
+}
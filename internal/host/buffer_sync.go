@@ -0,0 +1,132 @@
+package host
+
+import (
+	"log/slog"
+
+	"github.com/neovim/go-client/nvim"
+)
+
+// attachBuffer subscribes to change events of buf and fills the session
+// mirror from a full read of the buffer.
+func (c *Commands) attachBuffer(v *nvim.Nvim, buf nvim.Buffer, session *bufferSession) error {
+	c.mu.Lock()
+	attached := session.attached
+	c.mu.Unlock()
+
+	if !attached {
+		ok, err := v.AttachBuffer(buf, false, nil)
+		if err != nil {
+			return err
+		}
+		c.mu.Lock()
+		session.attached = ok
+		c.mu.Unlock()
+	}
+
+	return c.resyncBuffer(v, buf, session)
+}
+
+// resyncBuffer reads the whole buffer into the session mirror. Lines,
+// changedtick and name are read in one batch so that no change can slip in
+// between them.
+func (c *Commands) resyncBuffer(v *nvim.Nvim, buf nvim.Buffer, session *bufferSession) error {
+	var (
+		lines [][]byte
+		tick  int
+		path  string
+	)
+
+	b := v.NewBatch()
+	b.BufferLines(buf, 0, -1, true, &lines)
+	b.BufferChangedTick(buf, &tick)
+	b.BufferName(buf, &path)
+	if err := b.Execute(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	session.mirror.reset(lines, int64(tick))
+	session.path = path
+	c.mu.Unlock()
+
+	return nil
+}
+
+// bufferSession returns the preview session of buf, if any.
+func (c *Commands) bufferSession(buf nvim.Buffer) (*bufferSession, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	session, ok := c.sessions[buf]
+	return session, ok
+}
+
+// handleBufLines applies an nvim_buf_lines_event to the session mirror and
+// queues a render once the change is complete. A changedtick gap triggers a
+// full resync.
+func (c *Commands) handleBufLines(v *nvim.Nvim, args ...interface{}) {
+	ev, ok := parseBufLinesEvent(args)
+	if !ok {
+		return
+	}
+
+	session, ok := c.bufferSession(ev.buf)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	applied := session.mirror.apply(ev)
+	c.mu.Unlock()
+
+	if !applied {
+		slog.Debug("buffer changes out of sequence; reading it again", "session", session.id)
+		if err := c.resyncBuffer(v, ev.buf, session); err != nil {
+			slog.Warn("reading buffer failed", "session", session.id, "err", err)
+			return
+		}
+	} else if ev.more {
+		return
+	}
+	c.queueBuffer(session)
+}
+
+// handleBufChangedtick follows changedtick bumps that do not change text,
+// e.g. writing the buffer, so they are not mistaken for a gap.
+func (c *Commands) handleBufChangedtick(args ...interface{}) {
+	buf, tick, ok := parseBufTickEvent(args)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if session, ok := c.sessions[buf]; ok && !session.mirror.partial {
+		session.mirror.tick = tick
+	}
+}
+
+// handleBufDetach re-attaches when Neovim drops the subscription of a
+// previewed buffer, e.g. after :edit reloads it. The full buffer is sent
+// with the new subscription and replaces the mirror.
+func (c *Commands) handleBufDetach(v *nvim.Nvim, args ...interface{}) {
+	buf, _, ok := parseBufTickEvent(args)
+	if !ok {
+		return
+	}
+
+	session, ok := c.bufferSession(buf)
+	if !ok {
+		return
+	}
+
+	attached, err := v.AttachBuffer(buf, true, nil)
+	if err != nil {
+		slog.Warn("re-attaching to buffer failed", "session", session.id, "err", err)
+	}
+
+	c.mu.Lock()
+	session.attached = err == nil && attached
+	c.mu.Unlock()
+}
@@ -0,0 +1,96 @@
+package host
+
+import "go-live-markdown/internal/app"
+
+// Config holds the user options that the Lua plugin passes to the host
+// when a preview starts. See lua/go_live_markdown/init.lua for defaults.
+type Config struct {
+	Host      string `msgpack:"host"`
+	Port      int    `msgpack:"port"`
+	PortRange []int  `msgpack:"port_range"`
+
+	// Daemon shares one preview server between Neovim instances.
+	Daemon       bool   `msgpack:"daemon"`
+	DaemonSocket string `msgpack:"daemon_socket"`
+
+	// AssetRoots are extra directories local images may be loaded from.
+	AssetRoots []string `msgpack:"asset_roots"`
+
+	// FenceRenderers maps fence languages to commands that print SVG.
+	FenceRenderers map[string]string `msgpack:"fence_renderers"`
+
+	// Share selects where :GoLiveMarkdownShare serves viewers.
+	Share ShareConfig `msgpack:"share"`
+}
+
+// ShareConfig holds the sharing options.
+type ShareConfig struct {
+	Interface string `msgpack:"interface"`
+	Port      int    `msgpack:"port"`
+}
+
+// defaultConfig mirrors the defaults of the Lua plugin.
+func defaultConfig() Config {
+	return Config{
+		Host:      "127.0.0.1",
+		Port:      7777,
+		PortRange: []int{7777, 7799},
+	}
+}
+
+// withDefaults fills unset fields of cfg from defaultConfig.
+func (cfg *Config) withDefaults() Config {
+	out := defaultConfig()
+	if cfg == nil {
+		return out
+	}
+
+	if cfg.Host != "" {
+		out.Host = cfg.Host
+	}
+	if cfg.Port > 0 {
+		out.Port = cfg.Port
+	}
+	if len(cfg.PortRange) == 2 {
+		out.PortRange = cfg.PortRange
+	}
+	out.Daemon = cfg.Daemon
+	if cfg.DaemonSocket != "" {
+		out.DaemonSocket = cfg.DaemonSocket
+	}
+	out.AssetRoots = cfg.AssetRoots
+	out.FenceRenderers = cfg.FenceRenderers
+	out.Share = cfg.Share
+	return out
+}
+
+// options converts the user options into the preview transport settings.
+func (cfg Config) options() app.Options {
+	opts := app.Options{
+		Listen:         cfg.listenConfig(),
+		AssetRoots:     cfg.AssetRoots,
+		FenceRenderers: cfg.FenceRenderers,
+		Share:          app.ShareConfig{Interface: cfg.Share.Interface, Port: cfg.Share.Port},
+	}
+	if !cfg.Daemon {
+		return opts
+	}
+
+	opts.DaemonSocket = cfg.DaemonSocket
+	if opts.DaemonSocket == "" {
+		opts.DaemonSocket = defaultDaemonSocket()
+	}
+	opts.SpawnDaemon = func() error {
+		return spawnDaemon(opts.DaemonSocket, opts.Listen)
+	}
+	return opts
+}
+
+// listenConfig converts the user options into the preview listen settings.
+func (cfg Config) listenConfig() app.ListenConfig {
+	listen := app.ListenConfig{Host: cfg.Host, Port: cfg.Port}
+	if len(cfg.PortRange) == 2 && cfg.PortRange[0] > 0 && cfg.PortRange[0] <= cfg.PortRange[1] {
+		listen.PortRange = [2]int{cfg.PortRange[0], cfg.PortRange[1]}
+	}
+	return listen
+}
@@ -0,0 +1,91 @@
+package host
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"go-live-markdown/internal/app"
+)
+
+// DaemonCommand is the host subcommand that runs the shared preview daemon.
+const DaemonCommand = "daemon"
+
+// RunDaemon runs the shared preview daemon with the given command line
+// arguments. With -fork it relaunches itself detached and returns, so the
+// daemon outlives the Neovim instance that started it.
+func RunDaemon(args []string) error {
+	flags := flag.NewFlagSet(DaemonCommand, flag.ContinueOnError)
+	socket := flags.String("socket", defaultDaemonSocket(), "control socket `path`")
+	host := flags.String("host", "127.0.0.1", "preview server `host`")
+	port := flags.Int("port", 7777, "preferred preview server `port`")
+	portMin := flags.Int("port-min", 0, "first `port` probed when -port is busy")
+	portMax := flags.Int("port-max", 0, "last `port` probed when -port is busy")
+	fork := flags.Bool("fork", false, "relaunch detached and return immediately")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *fork {
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+
+		rest := make([]string, 0, len(args))
+		for _, arg := range args {
+			if arg != "-fork" && arg != "--fork" {
+				rest = append(rest, arg)
+			}
+		}
+
+		cmd := exec.Command(exe, append([]string{DaemonCommand}, rest...)...)
+		cmd.SysProcAttr = detachedProcAttr()
+		return cmd.Start()
+	}
+
+	return app.RunDaemon(*socket, app.ListenConfig{
+		Host:      *host,
+		Port:      *port,
+		PortRange: [2]int{*portMin, *portMax},
+	})
+}
+
+// spawnDaemon launches a detached daemon for socket and waits until the
+// intermediate process has handed it off.
+func spawnDaemon(socket string, listen app.ListenConfig) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(exe, DaemonCommand, "-fork",
+		"-socket", socket,
+		"-host", listen.Host,
+		"-port", strconv.Itoa(listen.Port),
+		"-port-min", strconv.Itoa(listen.PortRange[0]),
+		"-port-max", strconv.Itoa(listen.PortRange[1]),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, out)
+	}
+	return nil
+}
+
+// defaultDaemonSocket returns the per-user control socket path. Without
+// $XDG_RUNTIME_DIR the socket lives in a per-user directory under the
+// temporary directory, which the daemon creates with mode 0700.
+func defaultDaemonSocket() string {
+	name := "go-live-markdown"
+	if uid := os.Getuid(); uid >= 0 {
+		name = fmt.Sprintf("go-live-markdown-%d", uid)
+	}
+
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, name+".sock")
+	}
+	return filepath.Join(os.TempDir(), name, "daemon.sock")
+}
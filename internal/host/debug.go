@@ -0,0 +1,76 @@
+package host
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"go-live-markdown/internal/app"
+
+	"github.com/neovim/go-client/nvim"
+)
+
+// debugReport is shown by :GoLiveMarkdownDebug: the host's view of the
+// previewed buffers next to the preview server's /debug/state.
+type debugReport struct {
+	Buffers []bufferDebug  `json:"buffers"`
+	Preview app.DebugState `json:"preview"`
+}
+
+type bufferDebug struct {
+	Buffer   int    `json:"buffer"`
+	Session  string `json:"session"`
+	Path     string `json:"path"`
+	Attached bool   `json:"attached"`
+	Tick     int64  `json:"changedtick"`
+	Lines    int    `json:"lines"`
+}
+
+// GoLiveMarkdownDebug opens a scratch window with the state of the host
+// and the preview server as JSON.
+func (c *Commands) GoLiveMarkdownDebug(v *nvim.Nvim) error {
+	report := debugReport{Buffers: c.debugBuffers(), Preview: c.preview.DebugState()}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	lines := make([][]byte, 0, 64)
+	for _, line := range strings.Split(string(data), "\n") {
+		lines = append(lines, []byte(line))
+	}
+
+	if err := v.Command("new"); err != nil {
+		return err
+	}
+	buf, err := v.CurrentBuffer()
+	if err != nil {
+		return err
+	}
+
+	b := v.NewBatch()
+	b.SetBufferLines(buf, 0, -1, true, lines)
+	b.Command("setlocal buftype=nofile bufhidden=wipe noswapfile nomodified filetype=json")
+	return b.Execute()
+}
+
+// debugBuffers describes the previewed buffers, ordered by buffer number.
+func (c *Commands) debugBuffers() []bufferDebug {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := make([]bufferDebug, 0, len(c.sessions))
+	for buf, session := range c.sessions {
+		out = append(out, bufferDebug{
+			Buffer:   int(buf),
+			Session:  session.id,
+			Path:     session.path,
+			Attached: session.attached,
+			Tick:     session.mirror.tick,
+			Lines:    len(session.mirror.lines),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Buffer < out[j].Buffer })
+	return out
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package host
+
+import "syscall"
+
+// detachedProcAttr starts the daemon in its own session so it survives the
+// editor's process tree being torn down.
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
@@ -0,0 +1,44 @@
+package host
+
+// previewMetrics is returned by GoLiveMarkdownMetrics(), e.g. for use in a
+// statusline. Durations are in milliseconds; sizes in bytes, before
+// compression.
+type previewMetrics struct {
+	Renders      int     `msgpack:"renders"`
+	LastRenderMs float64 `msgpack:"last_render_ms"`
+	AvgRenderMs  float64 `msgpack:"avg_render_ms"`
+	MaxRenderMs  float64 `msgpack:"max_render_ms"`
+
+	Messages  int   `msgpack:"messages"`
+	BytesSent int64 `msgpack:"bytes_sent"`
+
+	ByType map[string]messageMetrics `msgpack:"by_type"`
+}
+
+type messageMetrics struct {
+	Count      int   `msgpack:"count"`
+	Compressed int   `msgpack:"compressed"`
+	Bytes      int64 `msgpack:"bytes"`
+	LastBytes  int   `msgpack:"last_bytes"`
+	MaxBytes   int   `msgpack:"max_bytes"`
+}
+
+// GoLiveMarkdownMetrics returns render durations and the sizes of the
+// messages sent to browsers since the host started.
+func (c *Commands) GoLiveMarkdownMetrics() (previewMetrics, error) {
+	metrics := c.preview.Metrics()
+
+	out := previewMetrics{
+		Renders:      metrics.Renders.Count,
+		LastRenderMs: metrics.Renders.LastMs,
+		AvgRenderMs:  metrics.Renders.AvgMs,
+		MaxRenderMs:  metrics.Renders.MaxMs,
+		ByType:       make(map[string]messageMetrics, len(metrics.Messages)),
+	}
+	for kind, m := range metrics.Messages {
+		out.Messages += m.Count
+		out.BytesSent += m.Bytes
+		out.ByType[kind] = messageMetrics(m)
+	}
+	return out, nil
+}
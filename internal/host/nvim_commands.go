@@ -2,9 +2,15 @@
 package host
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
+	"log/slog"
+	"os"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"go-live-markdown/internal/app"
 	"go-live-markdown/internal/contracts"
@@ -13,31 +19,54 @@ import (
 	"github.com/neovim/go-client/nvim/plugin"
 )
 
+// configEval is evaluated by Neovim to pass the Lua options to start commands.
+const configEval = `luaeval('require("go_live_markdown").host_config()')`
+
+// editorTimeout bounds how long a browser action waits for Neovim.
+const editorTimeout = 2 * time.Second
+
+var errEditorTimeout = errors.New("neovim did not respond in time")
+
 var taskListMarkerPattern = regexp.MustCompile(`^(\s*(?:[-*+]|\d+[.)])\s+\[)( |x|X)(\])`)
 
 // Commands is a state container for Neovim command handlers.
-// It tracks the active buffer and delegates preview functionality
+// It tracks the started buffers and delegates preview functionality
 // to the LivePreview service.
 type Commands struct {
 	preview *app.LivePreview
-	active  bool
 
 	nv *nvim.Nvim
 
+	mu       sync.Mutex
+	sessions map[nvim.Buffer]*bufferSession
+}
+
+// bufferSession is the editor-side state of a single previewed buffer.
+type bufferSession struct {
+	id   string
+	path string
+
+	// mirror holds the buffer contents while attached is set.
+	mirror   bufferMirror
+	attached bool
+
 	lastCursorLine int
 	lastCursorCol  int
 }
 
 // NewCommands constructs command handlers and wires browser callbacks.
 func NewCommands() *Commands {
-	preview := app.NewLivePreview("127.0.0.1:7777")
-	c := &Commands{preview: preview}
+	preview := app.NewLivePreview(defaultConfig().listenConfig())
+	c := &Commands{
+		preview:  preview,
+		sessions: make(map[nvim.Buffer]*bufferSession),
+	}
 
-	preview.SetGoToLineHandler(func(msg contracts.GoToLineMessage) {
-		c.handleGoToLine(msg)
+	preview.SetGoToLineHandler(func(session string, msg contracts.GoToLineMessage) {
+		c.handleGoToLine(session, msg)
 	})
-	preview.SetToggleCheckboxHandler(func(msg contracts.ToggleCheckboxMessage) {
-		c.handleToggleCheckbox(msg)
+	preview.SetToggleCheckboxHandler(func(session string, msg contracts.ToggleCheckboxMessage) {
+		c.handleToggleCheckbox(session, msg)
 	})
 	return c
 }
@@ -50,10 +79,56 @@ func Register(p *plugin.Plugin) error {
 		return "ok", nil
 	})
 
+	p.Handle(nvim.EventBufLines, commands.handleBufLines)
+	p.Handle(nvim.EventBufChangedtick, commands.handleBufChangedtick)
+	p.Handle(nvim.EventBufDetach, commands.handleBufDetach)
+
 	p.HandleCommand(&plugin.CommandOptions{
 		Name: "GoLiveMarkdownStart",
+		Eval: configEval,
 	}, commands.GoLiveMarkdownStart)
 
+	p.HandleCommand(&plugin.CommandOptions{
+		Name: "GoLiveMarkdownStop",
+		Bang: true,
+	}, commands.GoLiveMarkdownStop)
+
+	p.HandleCommand(&plugin.CommandOptions{
+		Name: "GoLiveMarkdownToggle",
+		Eval: configEval,
+	}, commands.GoLiveMarkdownToggle)
+
+	p.HandleCommand(&plugin.CommandOptions{
+		Name: "GoLiveMarkdownShare",
+		Eval: configEval,
+		Bang: true,
+	}, commands.GoLiveMarkdownShare)
+
+	p.HandleCommand(&plugin.CommandOptions{
+		Name: "GoLiveMarkdownViewers",
+	}, commands.GoLiveMarkdownViewers)
+
+	p.HandleCommand(&plugin.CommandOptions{
+		Name: "GoLiveMarkdownDebug",
+	}, commands.GoLiveMarkdownDebug)
+
+	p.HandleFunction(&plugin.FunctionOptions{
+		Name: "GoLiveMarkdownSelfCheck",
+		Eval: configEval,
+	}, commands.GoLiveMarkdownSelfCheck)
+
+	p.HandleFunction(&plugin.FunctionOptions{
+		Name: "GoLiveMarkdownStatus",
+	}, commands.GoLiveMarkdownStatus)
+
+	p.HandleFunction(&plugin.FunctionOptions{
+		Name: "GoLiveMarkdownStatusJSON",
+	}, commands.GoLiveMarkdownStatusJSON)
+
+	p.HandleFunction(&plugin.FunctionOptions{
+		Name: "GoLiveMarkdownMetrics",
+	}, commands.GoLiveMarkdownMetrics)
+
 	p.HandleFunction(&plugin.FunctionOptions{
 		Name: "GoLiveMarkdownInternalUpdate",
 	}, commands.GoLiveMarkdownUpdate)
@@ -62,82 +137,305 @@ func Register(p *plugin.Plugin) error {
 		Name: "GoLiveMarkdownInternalCursor",
 	}, commands.GoLiveMarkdownCursor)
 
+	p.HandleFunction(&plugin.FunctionOptions{
+		Name: "GoLiveMarkdownInternalWipe",
+	}, commands.GoLiveMarkdownWipe)
+
+	p.HandleFunction(&plugin.FunctionOptions{
+		Name: "GoLiveMarkdownInternalShutdown",
+	}, commands.GoLiveMarkdownShutdown)
+
 	return nil
 }
 
 // GoLiveMarkdownStart enables live preview for the current buffer.
-func (c *Commands) GoLiveMarkdownStart(v *nvim.Nvim) error {
-	c.active = true
-	c.lastCursorLine = 0
-	c.lastCursorCol = 0
+// Every started buffer gets its own preview session and URL. The options
+// in cfg apply when no preview is being served yet.
+func (c *Commands) GoLiveMarkdownStart(v *nvim.Nvim, cfg *Config) error {
+	buf, err := v.CurrentBuffer()
+	if err != nil {
+		return c.notifyError(v, fmt.Sprintf("[go-live-markdown] %v", err))
+	}
+
+	c.preview.Configure(cfg.withDefaults().options())
+
+	c.mu.Lock()
 	c.nv = v
+	session, ok := c.sessions[buf]
+	if !ok {
+		session = &bufferSession{id: sessionID(buf)}
+		c.sessions[buf] = session
+	}
+	session.lastCursorLine = 0
+	session.lastCursorCol = 0
+	c.mu.Unlock()
+
+	err = c.attachBuffer(v, buf, session)
+	if err == nil {
+		err = c.publishBuffer(session)
+	}
+	if err != nil {
+		c.mu.Lock()
+		delete(c.sessions, buf)
+		c.mu.Unlock()
+		return c.notifyError(v, fmt.Sprintf("[go-live-markdown] %v", err))
+	}
 
-	if err := c.publishBuffer(v); err != nil {
-		c.active = false
+	if err := c.publishCursor(v, session); err != nil {
 		return c.notifyError(v, fmt.Sprintf("[go-live-markdown] %v", err))
 	}
 
-	if err := c.publishCursor(v); err != nil {
+	return v.Command(fmt.Sprintf(`echom "[go-live-markdown] preview: %s (all documents: %s)"`,
+		c.preview.SessionURL(session.id), c.preview.URL()))
+}
+
+// GoLiveMarkdownStop stops the preview of the current buffer. With a bang,
+// every session is stopped. The preview server is shut down and its port
+// freed once no session is left.
+func (c *Commands) GoLiveMarkdownStop(v *nvim.Nvim, bang bool) error {
+	if bang {
+		if err := c.stopAll(); err != nil {
+			return c.notifyError(v, fmt.Sprintf("[go-live-markdown] %v", err))
+		}
+		return v.Command(`echom "[go-live-markdown] preview stopped"`)
+	}
+
+	buf, _, ok := c.currentSession(v)
+	if !ok {
+		return v.Command(`echom "[go-live-markdown] no preview for this buffer"`)
+	}
+
+	if err := c.stopBuffer(buf); err != nil {
 		return c.notifyError(v, fmt.Sprintf("[go-live-markdown] %v", err))
 	}
+	return v.Command(`echom "[go-live-markdown] preview stopped"`)
+}
 
-	return v.Command(fmt.Sprintf(`echom "[go-live-markdown] preview: %s"`, c.preview.URL()))
+// GoLiveMarkdownToggle starts the preview of the current buffer, or stops it
+// when the buffer is already previewed.
+func (c *Commands) GoLiveMarkdownToggle(v *nvim.Nvim, cfg *Config) error {
+	if _, _, ok := c.currentSession(v); ok {
+		return c.GoLiveMarkdownStop(v, false)
+	}
+	return c.GoLiveMarkdownStart(v, cfg)
 }
 
-func (c *Commands) notifyError(v *nvim.Nvim, msg string) error {
-	return v.Command(fmt.Sprintf(`echohl ErrorMsg | echom %q | echohl None`, msg))
+// GoLiveMarkdownShare shares the preview of the current buffer read-only
+// on the local network and prints the URL to hand to viewers. With a bang,
+// sharing stops and connected viewers are disconnected.
+func (c *Commands) GoLiveMarkdownShare(v *nvim.Nvim, bang bool, cfg *Config) error {
+	_, session, ok := c.currentSession(v)
+	if !ok {
+		return v.Command(`echom "[go-live-markdown] no preview for this buffer"`)
+	}
+
+	if bang {
+		c.preview.Unshare(session.id)
+		return v.Command(`echom "[go-live-markdown] sharing stopped"`)
+	}
+
+	c.preview.Configure(cfg.withDefaults().options())
+	url, err := c.preview.Share(session.id)
+	if err != nil {
+		return c.notifyError(v, fmt.Sprintf("[go-live-markdown] %v", err))
+	}
+	return v.Command(fmt.Sprintf(`echom "[go-live-markdown] shared read-only: %s"`, url))
+}
+
+// GoLiveMarkdownViewers lists the viewers connected to the shared preview
+// of the current buffer.
+func (c *Commands) GoLiveMarkdownViewers(v *nvim.Nvim) error {
+	_, session, ok := c.currentSession(v)
+	if !ok {
+		return v.Command(`echom "[go-live-markdown] no preview for this buffer"`)
+	}
+
+	viewers := c.preview.Viewers(session.id)
+	if len(viewers) == 0 {
+		return v.Command(`echom "[go-live-markdown] no viewers"`)
+	}
+
+	lines := make([]string, 0, len(viewers))
+	for _, viewer := range viewers {
+		lines = append(lines, fmt.Sprintf("%s  %s  since %s",
+			viewer.Addr, viewer.Browser, viewer.Since.Format("15:04:05")))
+	}
+	return v.Command(fmt.Sprintf("echo %q", strings.Join(lines, "\n")))
 }
 
-// GoLiveMarkdownUpdate publishes the current buffer contents when active.
-func (c *Commands) GoLiveMarkdownUpdate(v *nvim.Nvim) error {
-	if !c.active {
+// GoLiveMarkdownWipe drops the session of a wiped buffer.
+func (c *Commands) GoLiveMarkdownWipe(args []int) error {
+	if len(args) == 0 {
 		return nil
 	}
+	return c.stopBuffer(nvim.Buffer(args[0]))
+}
 
-	return c.publishBuffer(v)
+// GoLiveMarkdownShutdown tears down every session before Neovim exits.
+func (c *Commands) GoLiveMarkdownShutdown() error {
+	return c.stopAll()
 }
 
-// GoLiveMarkdownCursor publishes cursor updates when preview is active.
-func (c *Commands) GoLiveMarkdownCursor(v *nvim.Nvim) error {
-	if !c.active {
+// stopBuffer closes the session of buf and shuts the preview down when it
+// was the last one.
+func (c *Commands) stopBuffer(buf nvim.Buffer) error {
+	c.mu.Lock()
+	session, ok := c.sessions[buf]
+	if !ok {
+		c.mu.Unlock()
 		return nil
 	}
-	return c.publishCursor(v)
+	delete(c.sessions, buf)
+	remaining := len(c.sessions)
+	v := c.nv
+	c.mu.Unlock()
+
+	if v != nil {
+		// Wiped buffers are detached by Neovim already.
+		_, _ = v.DetachBuffer(buf)
+	}
+
+	if remaining == 0 {
+		return c.stopAll()
+	}
+
+	c.preview.CloseSession(session.id)
+	return nil
 }
 
-// currentPath resolves the absolute path for the current buffer.
-func (c *Commands) currentPath(v *nvim.Nvim) (string, error) {
-	absPath, err := v.BufferName(0)
-	if err != nil {
-		return "", err
+// stopAll resets the command state and shuts the preview server down.
+func (c *Commands) stopAll() error {
+	c.mu.Lock()
+	sessions := c.sessions
+	v := c.nv
+	c.sessions = make(map[nvim.Buffer]*bufferSession)
+	c.nv = nil
+	c.mu.Unlock()
+
+	if v != nil {
+		for buf := range sessions {
+			_, _ = v.DetachBuffer(buf)
+		}
 	}
 
-	return absPath, nil
+	return c.preview.Stop()
 }
 
-// publishBuffer reads the current buffer and sends rendered content to preview.
-func (c *Commands) publishBuffer(v *nvim.Nvim) error {
+func (c *Commands) notifyError(v *nvim.Nvim, msg string) error {
+	slog.Error(strings.TrimPrefix(msg, "[go-live-markdown] "))
+	return v.Command(fmt.Sprintf(`echohl ErrorMsg | echom %q | echohl None`, msg))
+}
+
+// GoLiveMarkdownUpdate checks the mirror of a buffer entering a window
+// when it has a preview session. It is an asynchronous notification with
+// args [buf]. Edits arrive as buffer events; the buffer is only read again
+// when it was detached or its changedtick shows missed changes.
+func (c *Commands) GoLiveMarkdownUpdate(v *nvim.Nvim, args []int) {
+	if len(args) == 0 {
+		return
+	}
+	buf := nvim.Buffer(args[0])
+	session, ok := c.bufferSession(buf)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	attached := session.attached
+	tick := session.mirror.tick
+	c.mu.Unlock()
+
+	if attached {
+		current, err := v.BufferChangedTick(buf)
+		if err != nil {
+			slog.Warn("reading changedtick failed", "session", session.id, "err", err)
+			return
+		}
+		if int64(current) == tick {
+			return
+		}
+		if err := c.resyncBuffer(v, buf, session); err != nil {
+			slog.Warn("reading buffer failed", "session", session.id, "err", err)
+			return
+		}
+	} else if err := c.attachBuffer(v, buf, session); err != nil {
+		slog.Warn("attaching to buffer failed", "session", session.id, "err", err)
+		return
+	}
+
+	c.queueBuffer(session)
+}
+
+// GoLiveMarkdownCursor publishes cursor updates of a previewed buffer. It is
+// an asynchronous notification with args [buf, line, col], so moving the
+// cursor never waits for the host.
+func (c *Commands) GoLiveMarkdownCursor(args []int) {
+	if len(args) < 3 {
+		return
+	}
+	session, ok := c.bufferSession(nvim.Buffer(args[0]))
+	if !ok {
+		return
+	}
+	_ = c.sendCursor(session, args[1], args[2])
+}
+
+// sessionID derives a stable preview session ID from a buffer handle.
+func sessionID(buf nvim.Buffer) string {
+	return strconv.Itoa(int(buf))
+}
+
+// currentSession returns the preview session of the current buffer, if any.
+func (c *Commands) currentSession(v *nvim.Nvim) (nvim.Buffer, *bufferSession, bool) {
 	buf, err := v.CurrentBuffer()
 	if err != nil {
 		// Keep the host alive when the active buffer becomes unavailable.
-		return nil
+		return 0, nil, false
 	}
 
-	lines, err := v.BufferLines(buf, 0, -1, true)
-	if err != nil {
-		return err
-	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	source := bytes.Join(lines, []byte("\n"))
-	path, err := c.currentPath(v)
-	if err != nil {
-		return err
+	session, ok := c.sessions[buf]
+	return buf, session, ok
+}
+
+// sessionBuffer resolves the buffer that owns a preview session.
+func (c *Commands) sessionBuffer(id string) (nvim.Buffer, *bufferSession, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for buf, session := range c.sessions {
+		if session.id == id {
+			return buf, session, true
+		}
 	}
-	return c.preview.PublishSource(source, path)
+	return 0, nil, false
+}
+
+// publishBuffer renders the mirror of a buffer and sends it to its session.
+func (c *Commands) publishBuffer(session *bufferSession) error {
+	c.mu.Lock()
+	source := session.mirror.source()
+	path := session.path
+	c.mu.Unlock()
+
+	return c.preview.PublishSource(session.id, source, path)
+}
+
+// queueBuffer schedules the mirror of a buffer for rendering in the
+// background.
+func (c *Commands) queueBuffer(session *bufferSession) {
+	c.mu.Lock()
+	source := session.mirror.source()
+	path := session.path
+	c.mu.Unlock()
+
+	c.preview.QueueSource(session.id, source, path)
 }
 
 // publishCursor sends the current cursor position when it changes.
-func (c *Commands) publishCursor(v *nvim.Nvim) error {
+func (c *Commands) publishCursor(v *nvim.Nvim, session *bufferSession) error {
 	var line int
 	if err := v.Eval(`line(".")`, &line); err != nil {
 		return err
@@ -148,59 +446,155 @@ func (c *Commands) publishCursor(v *nvim.Nvim) error {
 		return err
 	}
 
-	if line == c.lastCursorLine && col == c.lastCursorCol {
+	return c.sendCursor(session, line, col)
+}
+
+// sendCursor publishes a cursor position when it differs from the last one.
+func (c *Commands) sendCursor(session *bufferSession, line int, col int) error {
+	c.mu.Lock()
+	if line == session.lastCursorLine && col == session.lastCursorCol {
+		c.mu.Unlock()
 		return nil
 	}
+	session.lastCursorLine = line
+	session.lastCursorCol = col
+	c.mu.Unlock()
 
-	c.lastCursorLine = line
-	c.lastCursorCol = col
-	return c.preview.PublishCursor(line, col)
+	return c.preview.PublishCursor(session.id, line, col)
 }
 
 // handleGoToLine moves the Neovim cursor based on browser interaction.
-func (c *Commands) handleGoToLine(msg contracts.GoToLineMessage) {
-	if !c.active || c.nv == nil {
+// The buffer of the session is brought into view when it is not shown.
+// It runs on the preview dispatcher and gives up when Neovim is busy.
+func (c *Commands) handleGoToLine(id string, msg contracts.GoToLineMessage) {
+	c.mu.Lock()
+	v := c.nv
+	c.mu.Unlock()
+	if v == nil {
 		return
 	}
 
-	v := c.nv
+	buf, session, ok := c.sessionBuffer(id)
+	if !ok {
+		return
+	}
+	if msg.Include != "" {
+		c.openIncludedFile(v, id, buf, msg)
+		return
+	}
 
 	line := msg.Line
-	if line == c.lastCursorLine {
+	c.mu.Lock()
+	unchanged := line == session.lastCursorLine
+	c.mu.Unlock()
+	if unchanged {
 		return
 	}
 
-	win, err := v.CurrentWindow()
-	if err != nil {
+	var (
+		winID int
+		win   nvim.Window
+	)
+	b := v.NewBatch()
+	b.Call("bufwinid", &winID, int(buf))
+	b.CurrentWindow(&win)
+	if err := executeBatch(b); err != nil {
+		slog.Warn("finding the window of a buffer failed", "session", id, "err", err)
 		return
 	}
-	if err := v.SetWindowCursor(win, [2]int{line, 0}); err != nil {
+
+	// Focus a window showing buf, loading buf into the current window when
+	// it is not visible.
+	b = v.NewBatch()
+	if winID > 0 {
+		win = nvim.Window(winID)
+		b.SetCurrentWindow(win)
+	} else {
+		b.SetBufferToWindow(win, buf)
+	}
+	b.SetWindowCursor(win, [2]int{line, 0})
+	b.Command("normal! zz")
+	if err := executeBatch(b); err != nil {
+		slog.Warn("jumping to line failed", "session", id, "line", line, "err", err)
 		return
 	}
 
-	_ = v.Command("normal! zz")
-	c.lastCursorLine = line
-	c.lastCursorCol = 0
+	c.mu.Lock()
+	session.lastCursorLine = line
+	session.lastCursorCol = 0
+	c.mu.Unlock()
 }
 
-func (c *Commands) handleToggleCheckbox(msg contracts.ToggleCheckboxMessage) {
-	if !c.active || c.nv == nil {
+// openIncludedFile opens a file included into the preview of a session at
+// msg.Line, from the window showing the session's buffer when there is one.
+// The browser names the file by its include handle, so only files the
+// latest render of the session included can be opened.
+func (c *Commands) openIncludedFile(v *nvim.Nvim, id string, buf nvim.Buffer, msg contracts.GoToLineMessage) {
+	if msg.Line < 1 {
 		return
 	}
-	if msg.Line < 1 {
+	path, ok := c.preview.IncludedFile(id, msg.Include)
+	if !ok {
+		return
+	}
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		return
+	}
+
+	var (
+		winID   int
+		escaped string
+	)
+	b := v.NewBatch()
+	b.Call("bufwinid", &winID, int(buf))
+	b.Call("fnameescape", &escaped, path)
+	if err := executeBatch(b); err != nil {
+		slog.Warn("finding the window of a buffer failed", "session", id, "err", err)
 		return
 	}
 
+	// :drop goes to a window already showing the file, and splits the
+	// window when its buffer cannot be abandoned. cursor() stops at the
+	// last line of a file that got shorter.
+	b = v.NewBatch()
+	if winID > 0 {
+		b.SetCurrentWindow(nvim.Window(winID))
+	}
+	b.Command("drop " + escaped)
+	b.Command(fmt.Sprintf("call cursor(%d, 1)", msg.Line))
+	b.Command("normal! zz")
+	if err := executeBatch(b); err != nil {
+		slog.Warn("opening included file failed", "session", id, "path", path, "line", msg.Line, "err", err)
+	}
+}
+
+// handleToggleCheckbox flips the task list marker on a source line. It
+// runs on the preview dispatcher and gives up when Neovim is busy.
+func (c *Commands) handleToggleCheckbox(id string, msg contracts.ToggleCheckboxMessage) {
+	c.mu.Lock()
 	v := c.nv
+	c.mu.Unlock()
+	if v == nil {
+		return
+	}
+	if msg.Line < 1 {
+		return
+	}
 
-	buf, err := v.CurrentBuffer()
-	if err != nil {
+	buf, _, ok := c.sessionBuffer(id)
+	if !ok {
 		return
 	}
 
 	lineIndex := msg.Line - 1
-	lines, err := v.BufferLines(buf, lineIndex, lineIndex+1, true)
-	if err != nil || len(lines) != 1 {
+	var lines [][]byte
+	b := v.NewBatch()
+	b.BufferLines(buf, lineIndex, lineIndex+1, true, &lines)
+	if err := executeBatch(b); err != nil {
+		slog.Warn("reading task line failed", "session", id, "line", msg.Line, "err", err)
+		return
+	}
+	if len(lines) != 1 {
 		return
 	}
 
@@ -209,11 +603,31 @@ func (c *Commands) handleToggleCheckbox(msg contracts.ToggleCheckboxMessage) {
 		return
 	}
 
-	if err := v.SetBufferLines(buf, lineIndex, lineIndex+1, true, [][]byte{updatedLine}); err != nil {
-		return
+	// The edit comes back as a lines event, which re-renders the preview.
+	b = v.NewBatch()
+	b.SetBufferLines(buf, lineIndex, lineIndex+1, true, [][]byte{updatedLine})
+	if err := executeBatch(b); err != nil {
+		slog.Warn("toggling task failed", "session", id, "line", msg.Line, "err", err)
 	}
+}
 
-	_ = c.publishBuffer(v)
+// executeBatch runs b, giving up when Neovim does not answer within
+// editorTimeout. An abandoned batch still completes in the background.
+func executeBatch(b *nvim.Batch) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Execute()
+	}()
+
+	timer := time.NewTimer(editorTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return errEditorTimeout
+	}
 }
 
 func toggleCheckboxLine(line []byte) ([]byte, bool) {
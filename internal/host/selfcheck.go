@@ -0,0 +1,64 @@
+package host
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// selfCheckReport is returned by GoLiveMarkdownSelfCheck() for the Lua
+// health check.
+type selfCheckReport struct {
+	Version hostVersion   `msgpack:"version"`
+	Checks  []checkResult `msgpack:"checks"`
+}
+
+// hostVersion identifies the build of the host binary, so the health check
+// can tell a stale binary from the plugin checkout.
+type hostVersion struct {
+	Module   string `msgpack:"module"`
+	Revision string `msgpack:"revision"`
+	Modified bool   `msgpack:"modified"`
+	Go       string `msgpack:"go"`
+}
+
+type checkResult struct {
+	Name    string `msgpack:"name"`
+	Status  string `msgpack:"status"`
+	Message string `msgpack:"message"`
+}
+
+// GoLiveMarkdownSelfCheck runs the host self-check with args [sample],
+// where sample is a markdown file to render.
+func (c *Commands) GoLiveMarkdownSelfCheck(args []string, cfg *Config) (selfCheckReport, error) {
+	sample := ""
+	if len(args) > 0 {
+		sample = args[0]
+	}
+
+	report := selfCheckReport{Version: buildVersion()}
+	for _, check := range c.preview.SelfCheck(cfg.withDefaults().listenConfig(), sample) {
+		report.Checks = append(report.Checks, checkResult(check))
+	}
+	return report, nil
+}
+
+// buildVersion reads the version control stamp Go embeds in binaries built
+// from a checkout.
+func buildVersion() hostVersion {
+	version := hostVersion{Go: runtime.Version()}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return version
+	}
+	version.Module = info.Main.Version
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			version.Revision = setting.Value
+		case "vcs.modified":
+			version.Modified = setting.Value == "true"
+		}
+	}
+	return version
+}
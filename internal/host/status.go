@@ -0,0 +1,76 @@
+package host
+
+import (
+	"encoding/json"
+
+	"github.com/neovim/go-client/nvim"
+)
+
+// previewStatus is returned by GoLiveMarkdownStatus(), for statuslines, and
+// as JSON by GoLiveMarkdownStatusJSON(), for scripting.
+type previewStatus struct {
+	// Active is set when the buffer is being previewed.
+	Active bool   `msgpack:"active" json:"active"`
+	Buffer int    `msgpack:"buffer" json:"buffer"`
+	URL    string `msgpack:"url" json:"url"`
+	// Sessions counts every previewed buffer.
+	Sessions int    `msgpack:"sessions" json:"sessions"`
+	Browsers int    `msgpack:"browsers" json:"browsers"`
+	Viewers  int    `msgpack:"viewers" json:"viewers"`
+	Rev      uint64 `msgpack:"rev" json:"rev"`
+	// RenderMs is how long the latest render of the buffer took.
+	RenderMs float64 `msgpack:"render_ms" json:"render_ms"`
+	// LastError is why the latest render of the buffer failed. It is
+	// cleared once the buffer renders again.
+	LastError string `msgpack:"last_error" json:"last_error"`
+}
+
+// GoLiveMarkdownStatus reports the preview state of a buffer with args
+// [buf], or of the current buffer when buf is omitted or 0.
+func (c *Commands) GoLiveMarkdownStatus(v *nvim.Nvim, args []int) (previewStatus, error) {
+	var buf nvim.Buffer
+	if len(args) > 0 && args[0] > 0 {
+		buf = nvim.Buffer(args[0])
+	} else {
+		current, err := v.CurrentBuffer()
+		if err != nil {
+			return previewStatus{}, err
+		}
+		buf = current
+	}
+
+	c.mu.Lock()
+	session, active := c.sessions[buf]
+	sessions := len(c.sessions)
+	c.mu.Unlock()
+
+	status := previewStatus{
+		Active:   active,
+		Buffer:   int(buf),
+		Sessions: sessions,
+	}
+	if active {
+		info := c.preview.SessionStatus(session.id)
+		status.URL = info.URL
+		status.Browsers = info.Browsers
+		status.Viewers = info.Viewers
+		status.Rev = info.Rev
+		status.RenderMs = info.RenderMs
+		status.LastError = info.LastError
+	}
+	return status, nil
+}
+
+// GoLiveMarkdownStatusJSON is GoLiveMarkdownStatus encoded as a JSON object.
+func (c *Commands) GoLiveMarkdownStatusJSON(v *nvim.Nvim, args []int) (string, error) {
+	status, err := c.GoLiveMarkdownStatus(v, args)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := json.Marshal(status)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
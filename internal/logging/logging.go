@@ -0,0 +1,147 @@
+// Package logging sets up the structured log of the host and the preview
+// daemon and remembers recent problems for the debug views.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// FileEnv names the log file. Neovim passes it on to the host.
+	FileEnv = "NVIM_GO_LIVE_MARKDOWN_LOG_FILE"
+	// LevelEnv sets the lowest level written to the log file: debug, info,
+	// warn or error. The default is info.
+	LevelEnv = "NVIM_GO_LIVE_MARKDOWN_LOG_LEVEL"
+)
+
+// maxRecent bounds the number of remembered problems.
+const maxRecent = 32
+
+// Entry is a warning or error logged recently.
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+	Attrs   string    `json:"attrs,omitempty"`
+}
+
+var recent struct {
+	mu      sync.Mutex
+	entries []Entry
+}
+
+// Setup installs the default slog logger. Records go to the file named by
+// FileEnv, or only warnings and errors to stderr when it is unset. The
+// standard log package writes through the same logger. The returned
+// function closes the log file.
+func Setup() (func(), error) {
+	var (
+		out   io.Writer = os.Stderr
+		level           = slog.LevelWarn
+		done            = func() {}
+	)
+
+	if path := os.Getenv(FileEnv); path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return done, err
+		}
+		out = f
+		level = parseLevel(os.Getenv(LevelEnv))
+		done = func() { _ = f.Close() }
+	}
+
+	handler := slog.NewTextHandler(out, &slog.HandlerOptions{Level: level})
+	slog.SetDefault(slog.New(&recentHandler{Handler: handler}).With("pid", os.Getpid()))
+	return done, nil
+}
+
+func parseLevel(s string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// Recent returns the warnings and errors logged recently, oldest first.
+func Recent() []Entry {
+	recent.mu.Lock()
+	defer recent.mu.Unlock()
+
+	return append([]Entry(nil), recent.entries...)
+}
+
+// recentHandler remembers warnings and errors before passing records on,
+// whatever level the log file is written at.
+type recentHandler struct {
+	slog.Handler
+	attrs []slog.Attr
+}
+
+func (h *recentHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelWarn || h.Handler.Enabled(ctx, level)
+}
+
+func (h *recentHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelWarn {
+		remember(h.entry(r))
+	}
+	if !h.Handler.Enabled(ctx, r.Level) {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *recentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &recentHandler{
+		Handler: h.Handler.WithAttrs(attrs),
+		attrs:   append(append([]slog.Attr(nil), h.attrs...), attrs...),
+	}
+}
+
+func (h *recentHandler) WithGroup(name string) slog.Handler {
+	return &recentHandler{Handler: h.Handler.WithGroup(name), attrs: h.attrs}
+}
+
+func (h *recentHandler) entry(r slog.Record) Entry {
+	var attrs []string
+	add := func(a slog.Attr) bool {
+		if a.Key != "pid" {
+			attrs = append(attrs, a.String())
+		}
+		return true
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(add)
+
+	return Entry{
+		Time:    r.Time,
+		Level:   r.Level.String(),
+		Message: r.Message,
+		Attrs:   strings.Join(attrs, " "),
+	}
+}
+
+func remember(e Entry) {
+	recent.mu.Lock()
+	defer recent.mu.Unlock()
+
+	if len(recent.entries) == maxRecent {
+		recent.entries = append(recent.entries[:0], recent.entries[1:]...)
+	}
+	recent.entries = append(recent.entries, e)
+}
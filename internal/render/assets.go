@@ -0,0 +1,165 @@
+package render
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// AssetPrefix is the URL path under which local files referenced by a
+// document are served.
+const AssetPrefix = "/@mdfs/"
+
+// projectRootMarkers identify the root directory of a project.
+var projectRootMarkers = []string{".git", ".hg", ".jj"}
+
+// assetTable issues opaque handles for local files referenced by documents.
+// Only files inside an allowed root get a handle. A file keeps its handle
+// for the lifetime of the renderer, so re-renders produce identical markup.
+type assetTable struct {
+	mu      sync.Mutex
+	roots   []string
+	handles map[string]string
+}
+
+func newAssetTable() *assetTable {
+	return &assetTable{handles: make(map[string]string)}
+}
+
+// setRoots replaces the configured roots that are allowed for every
+// document, in addition to its own directory and project root.
+func (t *assetTable) setRoots(roots []string) {
+	resolved := make([]string, 0, len(roots))
+	for _, root := range roots {
+		if real, ok := resolveRoot(root); ok {
+			resolved = append(resolved, real)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.roots = resolved
+}
+
+// documentRoots returns the roots assets of the document at sourcePath
+// may come from: its directory, the enclosing project root and the
+// configured roots.
+func (t *assetTable) documentRoots(sourcePath string) []string {
+	t.mu.Lock()
+	roots := append([]string(nil), t.roots...)
+	t.mu.Unlock()
+
+	if sourcePath == "" {
+		return roots
+	}
+
+	dir, ok := resolveRoot(filepath.Dir(sourcePath))
+	if !ok {
+		return roots
+	}
+	roots = append(roots, dir)
+	if project, ok := projectRoot(dir); ok && project != dir {
+		roots = append(roots, project)
+	}
+	return roots
+}
+
+// register returns the handle of the file at path, resolving symlinks
+// first. It fails for missing files, directories and files outside roots.
+func (t *assetTable) register(path string, roots []string) (handle string, real string, ok bool) {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", "", false
+	}
+	info, err := os.Stat(real)
+	if err != nil || info.IsDir() {
+		return "", "", false
+	}
+	if !withinAny(real, roots) {
+		return "", "", false
+	}
+
+	return t.handle(real), real, true
+}
+
+// handle returns the handle of the file at the resolved path real, issuing
+// one on first use.
+func (t *assetTable) handle(real string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	handle, ok := t.handles[real]
+	if !ok {
+		handle = newAssetHandle()
+		t.handles[real] = handle
+	}
+	return handle
+}
+
+// ValidAsset reports whether path, as registered for a document, still
+// refers to the same regular file, i.e. it has not been replaced by a
+// symlink since the document was rendered.
+func ValidAsset(path string) bool {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil || real != path {
+		return false
+	}
+	info, err := os.Stat(real)
+	return err == nil && !info.IsDir()
+}
+
+func newAssetHandle() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// resolveRoot cleans an absolute root directory and resolves its symlinks.
+func resolveRoot(root string) (string, bool) {
+	if root == "" || !filepath.IsAbs(root) {
+		return "", false
+	}
+	real, err := filepath.EvalSymlinks(filepath.Clean(root))
+	if err != nil {
+		return "", false
+	}
+	return real, true
+}
+
+// projectRoot walks up from dir to the nearest directory holding a
+// version control marker.
+func projectRoot(dir string) (string, bool) {
+	for {
+		for _, marker := range projectRootMarkers {
+			if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+				return dir, true
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// withinAny reports whether path lies inside one of roots.
+func withinAny(path string, roots []string) bool {
+	for _, root := range roots {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			continue
+		}
+		if rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,232 @@
+package render
+
+import (
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/yuin/goldmark/ast"
+	extensionast "github.com/yuin/goldmark/extension/ast"
+)
+
+// maxCachedDocuments bounds the number of documents whose blocks are kept.
+const maxCachedDocuments = 16
+
+// blockCache keeps the rendered top-level blocks of recently rendered
+// documents, so an edit only renders the blocks it touched to HTML. Blocks
+// are looked up by a hash of their source and of everything they pick up
+// from the rest of the document, with source lines taken relative to the
+// block's first line; a block that only moved is reused with its lines
+// shifted.
+//
+// The whole document is still parsed and decorated on every render; the
+// ConvertDocument benchmarks show what the cache saves on top of that.
+type blockCache struct {
+	mu   sync.Mutex
+	docs map[string]*cachedDocument
+	seq  uint64
+}
+
+type cachedDocument struct {
+	used   uint64
+	blocks map[string]cachedBlock
+}
+
+// cachedBlock is a rendered block. line is the first source line its hash
+// was taken relative to.
+type cachedBlock struct {
+	line  int
+	block Block
+}
+
+func newBlockCache() *blockCache {
+	return &blockCache{docs: make(map[string]*cachedDocument)}
+}
+
+// blocks returns the cached blocks of the document at path.
+func (c *blockCache) blocks(path string) map[string]cachedBlock {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if doc, ok := c.docs[path]; ok {
+		return doc.blocks
+	}
+	return nil
+}
+
+// store replaces the cached blocks of the document at path with those of
+// its latest render, evicting the least recently rendered document when
+// too many are cached.
+func (c *blockCache) store(path string, blocks map[string]cachedBlock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	c.docs[path] = &cachedDocument{used: c.seq, blocks: blocks}
+	if len(c.docs) <= maxCachedDocuments {
+		return
+	}
+
+	oldest := ""
+	for p, doc := range c.docs {
+		if oldest == "" || doc.used < c.docs[oldest].used {
+			oldest = p
+		}
+	}
+	delete(c.docs, oldest)
+}
+
+// clear drops every cached block, for when the way blocks render changed.
+func (c *blockCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.docs = make(map[string]*cachedDocument)
+}
+
+// blockSpans returns the source range of every top-level node of doc: from
+// its first line to the start of the next block in the source. ok is false
+// for nodes without source lines, which are never cached.
+func blockSpans(doc ast.Node, source []byte) (starts []int, ends []int, ok []bool) {
+	for n := doc.FirstChild(); n != nil; n = n.NextSibling() {
+		start, found := firstNodeOffset(n)
+		starts = append(starts, start)
+		ok = append(ok, found)
+	}
+
+	sorted := make([]int, 0, len(starts))
+	for i, start := range starts {
+		if ok[i] {
+			sorted = append(sorted, start)
+		}
+	}
+	sort.Ints(sorted)
+
+	ends = make([]int, len(starts))
+	for i, start := range starts {
+		ends[i] = len(source)
+		if j := sort.SearchInts(sorted, start+1); j < len(sorted) {
+			ends[i] = sorted[j]
+		}
+	}
+	return starts, ends, ok
+}
+
+// hashBlock hashes the source of a top-level node together with the state
+// it takes from the rest of the document: attributes such as heading IDs
+// and source lines, resolved link references, footnote numbers and the
+// content of included files. It returns the hash and the first source
+// line, relative to which all lines are hashed.
+func hashBlock(n ast.Node, source []byte) (string, int) {
+	h := fnv.New128a()
+	_, _ = h.Write(source)
+
+	base := 0
+	_ = ast.Walk(n, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		writeHashString(h, node.Kind().String())
+		for _, attr := range node.Attributes() {
+			_, _ = h.Write(attr.Name)
+			value := attributeValue(attr.Value)
+			if string(attr.Name) == mdLineAttribute {
+				line, err := strconv.Atoi(value)
+				if err == nil {
+					if base == 0 {
+						base = line
+					}
+					value = strconv.Itoa(line - base)
+				}
+			}
+			writeHashString(h, value)
+		}
+
+		switch typed := node.(type) {
+		case *ast.Link:
+			_, _ = h.Write(typed.Destination)
+			_, _ = h.Write(typed.Title)
+		case *ast.Image:
+			_, _ = h.Write(typed.Destination)
+			_, _ = h.Write(typed.Title)
+		case *extensionast.FootnoteLink:
+			writeHashString(h, fmt.Sprint(typed.Index, typed.RefCount, typed.RefIndex))
+		case *extensionast.FootnoteBacklink:
+			writeHashString(h, fmt.Sprint(typed.Index, typed.RefCount, typed.RefIndex))
+		case *extensionast.Footnote:
+			writeHashString(h, fmt.Sprint(typed.Index))
+			_, _ = h.Write(typed.Ref)
+		case *includeBlock:
+			writeHashString(h, typed.html)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	return fmt.Sprintf("%x", h.Sum(nil)), base
+}
+
+// writeHashString writes s followed by a separator, so adjacent values
+// cannot run into each other.
+func writeHashString(h hash.Hash, s string) {
+	_, _ = h.Write([]byte(s))
+	_, _ = h.Write([]byte{0})
+}
+
+func attributeValue(v any) string {
+	switch typed := v.(type) {
+	case string:
+		return typed
+	case []byte:
+		return string(typed)
+	default:
+		return fmt.Sprint(typed)
+	}
+}
+
+// shiftBlock moves the source lines of a cached block by delta.
+func shiftBlock(block Block, delta int) Block {
+	if delta == 0 {
+		return block
+	}
+	if block.Line != 0 {
+		block.Line += delta
+	}
+	block.HTML = shiftLines(block.HTML, delta)
+	return block
+}
+
+// shiftLines adds delta to every data-md-line value in html.
+func shiftLines(html string, delta int) string {
+	marker := string(mdLineMarker)
+
+	var b strings.Builder
+	b.Grow(len(html))
+	rest := html
+	for {
+		i := strings.Index(rest, marker)
+		if i < 0 {
+			break
+		}
+		i += len(marker)
+		b.WriteString(rest[:i])
+		rest = rest[i:]
+
+		end := strings.IndexByte(rest, '"')
+		if end < 0 {
+			break
+		}
+		line, err := strconv.Atoi(rest[:end])
+		if err != nil {
+			continue
+		}
+		b.WriteString(strconv.Itoa(line + delta))
+		rest = rest[end:]
+	}
+	b.WriteString(rest)
+	return b.String()
+}
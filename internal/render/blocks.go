@@ -0,0 +1,103 @@
+package render
+
+import (
+	"bytes"
+	"encoding/hex"
+	"hash/fnv"
+	"strconv"
+
+	"github.com/yuin/goldmark/ast"
+	extensionast "github.com/yuin/goldmark/extension/ast"
+)
+
+// Block is a top-level block of a rendered document.
+type Block struct {
+	// Key hashes the block HTML with its source lines taken relative to
+	// Line, so a block that only moved up or down keeps its key.
+	Key string
+	// Line is the first source line the block's HTML refers to, or zero
+	// when it carries no line metadata.
+	Line int
+	HTML string
+}
+
+var mdLineMarker = []byte(mdLineAttribute + `="`)
+
+// renderBlocks renders every top-level node of doc on its own. Blocks that
+// are unchanged since the previous render of sourcePath are taken from the
+// cache instead, with their lines shifted to where they now are. Blocks
+// showing an external renderer that could not run are not cached, so the
+// command is tried again on the next render.
+func (r *Renderer) renderBlocks(doc ast.Node, source []byte, sourcePath string) ([]Block, error) {
+	blocks := make([]Block, 0, doc.ChildCount())
+	prev := r.blocks.blocks(sourcePath)
+	next := make(map[string]cachedBlock, doc.ChildCount())
+	starts, ends, cacheable := blockSpans(doc, source)
+
+	var buf bytes.Buffer
+	i := 0
+	for n := doc.FirstChild(); n != nil; n, i = n.NextSibling(), i+1 {
+		// Footnote definitions are collected from all over the source, so
+		// the footnote list is rendered every time.
+		hash, line := "", 0
+		if cacheable[i] && n.Kind() != extensionast.KindFootnoteList {
+			hash, line = hashBlock(n, source[starts[i]:ends[i]])
+			if cached, ok := prev[hash]; ok {
+				next[hash] = cachedBlock{line: line, block: shiftBlock(cached.block, line-cached.line)}
+				blocks = append(blocks, next[hash].block)
+				continue
+			}
+		}
+
+		buf.Reset()
+		failures := r.external.failures.Load()
+		if err := r.md.Renderer().Render(&buf, source, n); err != nil {
+			return nil, err
+		}
+
+		key, htmlLine := blockKey(buf.Bytes())
+		block := Block{Key: key, Line: htmlLine, HTML: buf.String()}
+		if hash != "" && r.external.failures.Load() == failures {
+			next[hash] = cachedBlock{line: line, block: block}
+		}
+		blocks = append(blocks, block)
+	}
+
+	r.blocks.store(sourcePath, next)
+	return blocks, nil
+}
+
+// blockKey hashes a rendered block. Every data-md-line value is hashed
+// relative to the first one, which is returned as the block's line.
+func blockKey(html []byte) (string, int) {
+	h := fnv.New128a()
+	base := 0
+
+	rest := html
+	for {
+		i := bytes.Index(rest, mdLineMarker)
+		if i < 0 {
+			break
+		}
+		i += len(mdLineMarker)
+		_, _ = h.Write(rest[:i])
+		rest = rest[i:]
+
+		end := bytes.IndexByte(rest, '"')
+		if end < 0 {
+			break
+		}
+		line, err := strconv.Atoi(string(rest[:end]))
+		if err != nil {
+			continue
+		}
+		if base == 0 {
+			base = line
+		}
+		_, _ = h.Write(strconv.AppendInt(nil, int64(line-base), 10))
+		rest = rest[end:]
+	}
+	_, _ = h.Write(rest)
+
+	return hex.EncodeToString(h.Sum(nil)), base
+}
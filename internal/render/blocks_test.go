@@ -0,0 +1,81 @@
+package render
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yuin/goldmark/text"
+)
+
+// benchmarkDocument generates a markdown document of about lines lines
+// mixing headings, prose, lists, tables, math and highlighted code.
+func benchmarkDocument(lines int) []byte {
+	var b strings.Builder
+	for section := 0; strings.Count(b.String(), "\n") < lines; section++ {
+		fmt.Fprintf(&b, "## Section %d\n\n", section)
+		fmt.Fprintf(&b, "Some *prose* with a [link](https://example.com/%d), `code` and $x_%d^2$.\n", section, section)
+		b.WriteString("It wraps onto a second line of the same paragraph.\n\n")
+		fmt.Fprintf(&b, "- [ ] task %d\n- item with **bold** text\n  - nested item\n\n", section)
+		b.WriteString("| a | b |\n| - | - |\n| 1 | 2 |\n\n")
+		fmt.Fprintf(&b, "```go\nfunc f%d(x int) int {\n\treturn x * %d\n}\n```\n\n", section, section)
+		b.WriteString("$$\n\\frac{a}{b} + \\sqrt{c}\n$$\n\n")
+	}
+	return []byte(b.String())
+}
+
+// editLine returns source with its middle paragraph changed, as a single
+// keystroke would.
+func editLine(source []byte, n int) []byte {
+	edited := strings.Replace(string(source), "It wraps onto a second line",
+		fmt.Sprintf("It wraps onto line %d", n), 1)
+	return []byte(edited)
+}
+
+// BenchmarkConvertDocumentCold renders a one-line edit of a 10k-line
+// document with an empty block cache.
+func BenchmarkConvertDocumentCold(b *testing.B) {
+	source := benchmarkDocument(10000)
+	path := filepath.Join(b.TempDir(), "doc.md")
+	r := NewRenderer()
+
+	for i := 0; b.Loop(); i++ {
+		r.blocks.clear()
+		if _, err := r.ConvertDocumentWithSourcePath(editLine(source, i), path); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkConvertDocumentCached renders a one-line edit of a 10k-line
+// document whose other blocks are cached.
+func BenchmarkConvertDocumentCached(b *testing.B) {
+	source := benchmarkDocument(10000)
+	path := filepath.Join(b.TempDir(), "doc.md")
+	r := NewRenderer()
+	if _, err := r.ConvertDocumentWithSourcePath(source, path); err != nil {
+		b.Fatal(err)
+	}
+
+	for i := 0; b.Loop(); i++ {
+		if _, err := r.ConvertDocumentWithSourcePath(editLine(source, i), path); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkConvertDocumentParse only parses and decorates the edited
+// document, which every render does in full; this is the part of a render
+// the block cache cannot save.
+func BenchmarkConvertDocumentParse(b *testing.B) {
+	source := benchmarkDocument(10000)
+	path := filepath.Join(b.TempDir(), "doc.md")
+	r := NewRenderer()
+
+	for i := 0; b.Loop(); i++ {
+		edited := editLine(source, i)
+		doc := r.md.Parser().Parse(text.NewReader(edited))
+		decorateAST(doc, edited, path, r.assetRegistrar(path, make(map[string]string)), r.isDiagramLanguage)
+	}
+}
@@ -0,0 +1,136 @@
+package render
+
+import (
+	"hash/fnv"
+	stdhtml "html"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/util"
+)
+
+// diagramLanguages are the fence languages drawn as diagrams by a runtime
+// in the browser instead of being highlighted as code. Languages with an
+// external renderer are drawn by the host.
+var diagramLanguages = map[string]bool{
+	"mermaid": true,
+}
+
+// kindDiagram is the node kind of a fenced diagram.
+var kindDiagram = ast.NewNodeKind("Diagram")
+
+// diagramBlock replaces a fenced code block whose language is a diagram
+// language. It keeps the fence's lines and attributes.
+type diagramBlock struct {
+	ast.BaseBlock
+	language string
+	// dir is the directory of the document, which external renderers run
+	// in.
+	dir string
+}
+
+func (n *diagramBlock) Kind() ast.NodeKind {
+	return kindDiagram
+}
+
+func (n *diagramBlock) IsRaw() bool {
+	return true
+}
+
+func (n *diagramBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Language": n.language}, nil)
+}
+
+// fenceLanguage returns the language of a fenced code block, lowercased.
+func fenceLanguage(fence *ast.FencedCodeBlock, source []byte) string {
+	return strings.ToLower(strings.TrimSpace(string(fence.Language(source))))
+}
+
+// replaceDiagramFence swaps a diagram fence for a diagramBlock in the tree.
+func replaceDiagramFence(fence *ast.FencedCodeBlock, language, dir string) {
+	parent := fence.Parent()
+	if parent == nil {
+		return
+	}
+
+	diagram := &diagramBlock{language: language, dir: dir}
+	diagram.SetLines(fence.Lines())
+	for _, attr := range fence.Attributes() {
+		diagram.SetAttribute(attr.Name, attr.Value)
+	}
+	parent.ReplaceChild(parent, fence, diagram)
+}
+
+// diagramRenderer renders diagram blocks as containers holding their
+// source. Diagrams of a language with an external renderer come with the
+// SVG it printed, or its error; the others are handed by the page to the
+// diagram runtime. The source hash lets the page reuse drawings of
+// diagrams that did not change.
+type diagramRenderer struct {
+	external *externalRenderers
+}
+
+func (r *diagramRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindDiagram, r.renderDiagram)
+}
+
+func (r *diagramRenderer) renderDiagram(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	diagram := n.(*diagramBlock)
+
+	var code strings.Builder
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		segment := lines.At(i)
+		code.Write(segment.Value(source))
+	}
+
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(diagram.language))
+	_, _ = hash.Write([]byte{0})
+	_, _ = hash.Write([]byte(code.String()))
+
+	_, _ = w.WriteString(`<div class="diagram" data-diagram="`)
+	_, _ = w.WriteString(stdhtml.EscapeString(diagram.language))
+	_, _ = w.WriteString(`" data-diagram-hash="`)
+	_, _ = w.WriteString(strconv.FormatUint(hash.Sum64(), 16))
+	_, _ = w.WriteString(`"`)
+
+	var output externalOutput
+	external := r.external.handles(diagram.language)
+	if external {
+		output = r.external.render(diagram.language, diagram.dir, []byte(code.String()))
+		_, _ = w.WriteString(` data-diagram-external`)
+		if output.err != "" {
+			_, _ = w.WriteString(` data-diagram-state="error"`)
+		} else {
+			_, _ = w.WriteString(` data-diagram-state="drawn"`)
+		}
+	}
+
+	html.RenderAttributes(w, n, nil)
+	_, _ = w.WriteString(`><pre class="diagram-source">`)
+	_, _ = w.WriteString(stdhtml.EscapeString(code.String()))
+	_, _ = w.WriteString("</pre>")
+	switch {
+	case external && output.err != "":
+		_, _ = w.WriteString(`<div class="diagram-output diagram-error">`)
+		_, _ = w.WriteString(stdhtml.EscapeString(output.err))
+		_, _ = w.WriteString(`</div>`)
+	case external:
+		// The SVG is shown as an image, so scripts and event handlers a
+		// fence smuggled into it through labels never run in the page.
+		_, _ = w.WriteString(`<div class="diagram-output"><img alt="`)
+		_, _ = w.WriteString(stdhtml.EscapeString(diagram.language))
+		_, _ = w.WriteString(`" src="`)
+		_, _ = w.WriteString(svgDataURI(output.svg))
+		_, _ = w.WriteString(`"></div>`)
+	}
+	_, _ = w.WriteString("</div>\n")
+	return ast.WalkSkipChildren, nil
+}
@@ -0,0 +1,188 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// externalTimeout bounds a single run of an external fence renderer.
+const externalTimeout = 10 * time.Second
+
+// maxExternalOutputs bounds the number of outputs of external fence
+// renderers that are kept.
+const maxExternalOutputs = 128
+
+// externalRenderers runs the commands configured for fence languages, such
+// as "dot -Tsvg", with the contents of a fence on stdin, and keeps the SVG
+// they print. Outputs are cached by command, directory and contents, so
+// only changed fences run their command again.
+type externalRenderers struct {
+	mu       sync.Mutex
+	commands map[string][]string
+	outputs  map[string]externalOutput
+	// order lists the cached outputs from oldest to newest.
+	order []string
+	// failures counts the runs whose output was not cached, so that blocks
+	// showing them are not cached either.
+	failures atomic.Uint64
+}
+
+// externalOutput is the SVG printed by an external renderer, or the
+// reason it failed.
+type externalOutput struct {
+	svg string
+	err string
+}
+
+func newExternalRenderers() *externalRenderers {
+	return &externalRenderers{
+		commands: make(map[string][]string),
+		outputs:  make(map[string]externalOutput),
+	}
+}
+
+// set replaces the configured commands, which map a fence language to a
+// command line split on whitespace. It reports whether they changed.
+func (e *externalRenderers) set(commands map[string]string) bool {
+	next := make(map[string][]string, len(commands))
+	for language, command := range commands {
+		language = strings.ToLower(strings.TrimSpace(language))
+		if argv := strings.Fields(command); language != "" && len(argv) > 0 {
+			next[language] = argv
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	changed := len(next) != len(e.commands)
+	for language, argv := range next {
+		if strings.Join(argv, " ") != strings.Join(e.commands[language], " ") {
+			changed = true
+		}
+	}
+	e.commands = next
+	return changed
+}
+
+// handles reports whether a command is configured for language.
+func (e *externalRenderers) handles(language string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, ok := e.commands[language]
+	return ok
+}
+
+// render returns the output of the command for language run in dir with
+// input on stdin.
+func (e *externalRenderers) render(language, dir string, input []byte) externalOutput {
+	e.mu.Lock()
+	argv, ok := e.commands[language]
+	e.mu.Unlock()
+	if !ok {
+		return externalOutput{err: fmt.Sprintf("no renderer is configured for %q", language)}
+	}
+
+	hash := fnv.New128a()
+	_, _ = hash.Write([]byte(strings.Join(argv, "\x00")))
+	_, _ = hash.Write([]byte{0})
+	_, _ = hash.Write([]byte(dir))
+	_, _ = hash.Write([]byte{0})
+	_, _ = hash.Write(input)
+	key := string(hash.Sum(nil))
+
+	e.mu.Lock()
+	output, ok := e.outputs[key]
+	e.mu.Unlock()
+	if ok {
+		return output
+	}
+
+	output, cacheable := runExternal(argv, dir, input)
+	if !cacheable {
+		e.failures.Add(1)
+		slog.Warn("external fence renderer failed", "language", language, "command", argv[0], "err", output.err)
+		return output
+	}
+	e.store(key, output)
+	return output
+}
+
+// store caches output under key, evicting the oldest outputs when too many
+// are kept.
+func (e *externalRenderers) store(key string, output externalOutput) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.outputs[key]; !ok {
+		e.order = append(e.order, key)
+	}
+	e.outputs[key] = output
+	for len(e.order) > maxExternalOutputs {
+		delete(e.outputs, e.order[0])
+		e.order = e.order[1:]
+	}
+}
+
+// svgDataURI returns a data: URI of svg. An image needs the SVG namespace,
+// which tools may leave out when printing inline SVG.
+func svgDataURI(svg string) string {
+	if end := strings.IndexByte(svg, '>'); end > 0 && !strings.Contains(svg[:end], "xmlns=") {
+		svg = `<svg xmlns="http://www.w3.org/2000/svg"` + strings.TrimPrefix(svg, "<svg")
+	}
+	return "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString([]byte(svg))
+}
+
+// runExternal runs argv and returns the SVG it printed. Failures that
+// depend on the input are cacheable; a command that could not start or
+// timed out is tried again on the next render.
+func runExternal(argv []string, dir string, input []byte) (externalOutput, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), externalTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = dir
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Do not wait for children of the command that keep its output open
+	// once it is killed.
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return externalOutput{err: fmt.Sprintf("%s timed out after %s", argv[0], externalTimeout)}, false
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			message = fmt.Sprintf("%s failed: %v", argv[0], err)
+		}
+		return externalOutput{err: message}, true
+	}
+	if err != nil {
+		return externalOutput{err: err.Error()}, false
+	}
+
+	// Drop the XML declaration and doctype tools print before the SVG.
+	out := stdout.String()
+	start := strings.Index(out, "<svg")
+	if start < 0 {
+		return externalOutput{err: fmt.Sprintf("%s printed no SVG", argv[0])}, true
+	}
+	return externalOutput{svg: out[start:]}, true
+}
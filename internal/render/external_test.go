@@ -0,0 +1,70 @@
+package render
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestExternalRendererRetriedOnceCommandAppears(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the fence renderer")
+	}
+
+	dir := t.TempDir()
+	command := filepath.Join(dir, "fake-dot")
+	doc := filepath.Join(dir, "doc.md")
+	source := []byte("# Graph\n\n```dot\ndigraph { a -> b }\n```\n")
+
+	r := NewRenderer()
+	r.SetFenceRenderers(map[string]string{"dot": command})
+
+	first, err := r.ConvertDocumentWithSourcePath(source, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(first.HTML, "diagram-error") {
+		t.Fatalf("missing command rendered without an error:\n%s", first.HTML)
+	}
+
+	script := "#!/bin/sh\ncat >/dev/null\necho '<svg>drawn</svg>'\n"
+	if err := os.WriteFile(command, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := r.ConvertDocumentWithSourcePath(source, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(second.HTML, "diagram-error") || !strings.Contains(second.HTML, svgDataURI("<svg>drawn</svg>")) {
+		t.Fatalf("command was not run again once it appeared:\n%s", second.HTML)
+	}
+}
+
+func TestExternalRendererOutputIsNotInlined(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the fence renderer")
+	}
+
+	dir := t.TempDir()
+	command := filepath.Join(dir, "fake-dot")
+	script := "#!/bin/sh\ncat >/dev/null\necho '<svg onload=\"alert(1)\"><script>alert(2)</script></svg>'\n"
+	if err := os.WriteFile(command, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRenderer()
+	r.SetFenceRenderers(map[string]string{"dot": command})
+	doc, err := r.ConvertDocumentWithSourcePath([]byte("```dot\ndigraph { a -> b }\n```\n"), filepath.Join(dir, "doc.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(doc.HTML, "<script") || strings.Contains(doc.HTML, "onload") {
+		t.Fatalf("renderer output was inlined into the page:\n%s", doc.HTML)
+	}
+	if !strings.Contains(doc.HTML, `src="data:image/svg+xml;base64,`) {
+		t.Fatalf("renderer output is not shown as an image:\n%s", doc.HTML)
+	}
+}
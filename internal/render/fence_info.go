@@ -0,0 +1,158 @@
+package render
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// fenceInfo is the info string of a fenced code block split into its
+// language and the attributes that follow it, as in
+//
+//	go file=main.go#L10-L40
+type fenceInfo struct {
+	language string
+	attrs    []fenceAttr
+}
+
+// fenceAttr is an attribute of an info string. Bare words have no value.
+type fenceAttr struct {
+	key   string
+	value string
+	// raw is the attribute as written.
+	raw string
+}
+
+// parseFenceInfo splits info into words. A word is a key, key=value,
+// key="quoted value" or a list of lines such as {3,7-9}. The first word is
+// the language unless it holds an attribute.
+func parseFenceInfo(info string) fenceInfo {
+	var parsed fenceInfo
+	for i, word := range splitFenceInfo(info) {
+		key, value, hasValue := strings.Cut(word, "=")
+		if i == 0 && !hasValue && !strings.HasPrefix(word, "{") {
+			parsed.language = word
+			continue
+		}
+		if hasValue && len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		parsed.attrs = append(parsed.attrs, fenceAttr{key: key, value: value, raw: word})
+	}
+	return parsed
+}
+
+// splitFenceInfo splits info on whitespace outside of double quotes and
+// braces.
+func splitFenceInfo(info string) []string {
+	var (
+		words  []string
+		word   strings.Builder
+		quoted bool
+		braced bool
+	)
+	for _, c := range info {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			word.WriteRune(c)
+		case !quoted && (c == '{' || c == '}'):
+			braced = c == '{'
+			word.WriteRune(c)
+		case !quoted && !braced && (c == ' ' || c == '\t'):
+			if word.Len() > 0 {
+				words = append(words, word.String())
+				word.Reset()
+			}
+		default:
+			word.WriteRune(c)
+		}
+	}
+	if word.Len() > 0 {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// attr returns the value of the attribute key.
+func (f fenceInfo) attr(key string) (string, bool) {
+	for _, attr := range f.attrs {
+		if attr.key == key {
+			return attr.value, true
+		}
+	}
+	return "", false
+}
+
+// without returns the info string with the attributes key left out.
+func (f fenceInfo) without(key string) string {
+	words := make([]string, 0, len(f.attrs)+1)
+	if f.language != "" {
+		words = append(words, f.language)
+	}
+	for _, attr := range f.attrs {
+		if attr.key != key {
+			words = append(words, attr.raw)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// codeTitleAttribute holds the title shown above a code block, such as its
+// file name.
+const codeTitleAttribute = "title"
+
+// setCodeBlockAttributes translates the attributes of a fence's info
+// string into those goldmark-highlighting hands to Chroma: {3,7-9}
+// highlights lines of the block, linenos adds a line number gutter and
+// start=40 numbers the lines from 40. title="main.go" is kept for
+// renderHighlightedCodeWrapper to show above the code.
+func setCodeBlockAttributes(fence *ast.FencedCodeBlock, source []byte) {
+	if fence.Info == nil {
+		return
+	}
+
+	info := parseFenceInfo(string(fence.Info.Segment.Value(source)))
+	for _, attr := range info.attrs {
+		switch {
+		case attr.key == "title" && attr.value != "":
+			fence.SetAttributeString(codeTitleAttribute, attr.value)
+		case attr.key == "linenos":
+			fence.SetAttributeString("linenos", true)
+		case attr.key == "start":
+			if start, err := strconv.Atoi(attr.value); err == nil && start >= 0 {
+				fence.SetAttributeString("linenostart", float64(start))
+			}
+		case strings.HasPrefix(attr.key, "{") && strings.HasSuffix(attr.key, "}"):
+			if lines := parseLineList(attr.key[1 : len(attr.key)-1]); len(lines) > 0 {
+				fence.SetAttributeString("hl_lines", lines)
+			}
+		}
+	}
+}
+
+// parseLineList parses a list of lines such as "3,7-9" into the form
+// goldmark-highlighting takes: a number for a line and "7-9" for a range.
+// Malformed entries are dropped.
+func parseLineList(list string) []any {
+	var lines []any
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		first, last, isRange := strings.Cut(entry, "-")
+		start, err := strconv.Atoi(strings.TrimSpace(first))
+		if err != nil || start < 1 {
+			continue
+		}
+		if !isRange {
+			lines = append(lines, float64(start))
+			continue
+		}
+		end, err := strconv.Atoi(strings.TrimSpace(last))
+		if err != nil || end < start {
+			continue
+		}
+		lines = append(lines, []byte(strconv.Itoa(start)+"-"+strconv.Itoa(end)))
+	}
+	return lines
+}
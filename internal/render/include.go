@@ -0,0 +1,446 @@
+package render
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	stdhtml "html"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+	"go.abhg.dev/goldmark/wikilink"
+)
+
+// includeLineAttribute holds the line of included content in the file it
+// was included from. It takes the place of data-md-line, which always
+// refers to the previewed document.
+const includeLineAttribute = "data-include-line"
+
+// maxIncludeDepth bounds how deeply includes may nest.
+const maxIncludeDepth = 8
+
+// maxIncludeSize bounds the size of an included file.
+const maxIncludeSize = 1 << 20
+
+// markdownExtensions are the extensions of files included as markdown.
+// Other files are included as code.
+var markdownExtensions = map[string]bool{
+	".md":       true,
+	".markdown": true,
+	".mdown":    true,
+	".mkd":      true,
+}
+
+// kindInclude is the node kind of included content.
+var kindInclude = ast.NewNodeKind("Include")
+
+// includeBlock replaces an include directive, an embedded wikilink or a
+// fence with a file attribute. It holds the rendered content of the
+// included file, or the reason it could not be included.
+type includeBlock struct {
+	ast.BaseBlock
+	html string
+}
+
+func (n *includeBlock) Kind() ast.NodeKind {
+	return kindInclude
+}
+
+func (n *includeBlock) IsRaw() bool {
+	return true
+}
+
+func (n *includeBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// includeTarget is a file to include, as written in the document.
+type includeTarget struct {
+	// raw is the target as written, shown above the included content.
+	raw  string
+	path string
+	// start and end are the 1-based lines to include, or zero for the
+	// whole file.
+	start, end int
+	markdown   bool
+	// info is the info string of the fence code is shown in.
+	info string
+}
+
+// parseIncludeTarget parses a path with an optional line range, as in
+// "snippets/setup.sh#L10-L40" or "main.go#L7".
+func parseIncludeTarget(raw string) (includeTarget, error) {
+	target := includeTarget{raw: raw, path: raw}
+	if i := strings.LastIndexByte(raw, '#'); i >= 0 {
+		target.path = raw[:i]
+		first, last, isRange := strings.Cut(raw[i+1:], "-")
+		start, err := parseLineRef(first)
+		if err != nil {
+			return target, err
+		}
+		end := start
+		if isRange {
+			if end, err = parseLineRef(last); err != nil {
+				return target, err
+			}
+		}
+		if end < start {
+			return target, fmt.Errorf("line range %s ends before it starts", raw[i+1:])
+		}
+		target.start, target.end = start, end
+	}
+	if target.path == "" {
+		return target, errors.New("no file to include")
+	}
+
+	target.markdown = markdownExtensions[strings.ToLower(filepath.Ext(target.path))]
+	target.info = strings.TrimPrefix(filepath.Ext(target.path), ".")
+	return target, nil
+}
+
+// parseLineRef parses a line reference such as "L10".
+func parseLineRef(ref string) (int, error) {
+	line, err := strconv.Atoi(strings.TrimPrefix(ref, "L"))
+	if err != nil || line < 1 {
+		return 0, fmt.Errorf("%q is not a line such as L10", ref)
+	}
+	return line, nil
+}
+
+// includeDirective returns the target of an include directive, an HTML
+// comment of the form <!-- include: path -->.
+func includeDirective(comment string) (string, bool) {
+	comment = strings.TrimSpace(comment)
+	if !strings.HasPrefix(comment, "<!--") || !strings.HasSuffix(comment, "-->") {
+		return "", false
+	}
+	body := strings.TrimSpace(comment[len("<!--") : len(comment)-len("-->")])
+	target, ok := strings.CutPrefix(body, "include:")
+	if !ok {
+		return "", false
+	}
+	target = strings.TrimSpace(target)
+	if target == "" || strings.Contains(target, "-->") || strings.ContainsRune(target, '\n') {
+		return "", false
+	}
+	return target, true
+}
+
+// embeddedNote returns the note embedded by a paragraph that holds nothing
+// but an embedded wikilink, as in ![[other-note]], and the wikilink as
+// written.
+func embeddedNote(n ast.Node, source []byte) (string, string, bool) {
+	var link *wikilink.Node
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if t, ok := c.(*ast.Text); ok && len(bytes.TrimSpace(t.Segment.Value(source))) == 0 {
+			continue
+		}
+		l, ok := c.(*wikilink.Node)
+		if !ok || link != nil {
+			return "", "", false
+		}
+		link = l
+	}
+	if link == nil || !link.Embed {
+		return "", "", false
+	}
+
+	target := string(link.Target)
+	written := "![[" + target + "]]"
+	switch ext := strings.ToLower(filepath.Ext(target)); {
+	case ext == "":
+		target += ".md"
+	case !markdownExtensions[ext]:
+		return "", "", false
+	}
+	return target, written, true
+}
+
+// transclusion includes files into a document and the files it includes.
+type transclusion struct {
+	r *Renderer
+	// roots are the directories files may be included from.
+	roots  []string
+	assets map[string]string
+	// files maps the handles of included files to their paths. Browsers
+	// only see the handles, and the editor only opens files listed here.
+	files map[string]string
+	// chain lists the files being included, outermost first.
+	chain []string
+}
+
+// transclude replaces the includes of the document at sourcePath with
+// includeBlocks. Includes are resolved relative to the document, so a
+// document without a path includes nothing.
+func (t *transclusion) transclude(doc ast.Node, source []byte, sourcePath string) {
+	if sourcePath == "" {
+		return
+	}
+	baseDir := filepath.Dir(sourcePath)
+	lines := newLineIndex(source)
+
+	type include struct {
+		node ast.Node
+		// offset is where the include starts in source.
+		offset int
+		target includeTarget
+		err    error
+	}
+	var includes []include
+
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		switch typed := n.(type) {
+		case *ast.FencedCodeBlock:
+			if typed.Info == nil {
+				return ast.WalkSkipChildren, nil
+			}
+			info := parseFenceInfo(string(typed.Info.Segment.Value(source)))
+			file, ok := info.attr("file")
+			if !ok {
+				return ast.WalkSkipChildren, nil
+			}
+			target, err := parseIncludeTarget(file)
+			target.markdown = false
+			if info.language == "" {
+				info.language = target.info
+			}
+			// Number the lines of a range as they are numbered in the file.
+			if _, ok := info.attr("start"); !ok && target.start > 0 {
+				start := strconv.Itoa(target.start)
+				info.attrs = append(info.attrs, fenceAttr{key: "start", value: start, raw: "start=" + start})
+			}
+			target.info = info.without("file")
+			includes = append(includes, include{node: n, offset: typed.Info.Segment.Start, target: target, err: err})
+			return ast.WalkSkipChildren, nil
+
+		case *ast.HTMLBlock:
+			var comment strings.Builder
+			for i := 0; i < typed.Lines().Len(); i++ {
+				segment := typed.Lines().At(i)
+				comment.Write(segment.Value(source))
+			}
+			if typed.HasClosure() {
+				comment.Write(typed.ClosureLine.Value(source))
+			}
+			file, ok := includeDirective(comment.String())
+			if !ok || typed.Lines().Len() == 0 {
+				return ast.WalkSkipChildren, nil
+			}
+			target, err := parseIncludeTarget(file)
+			includes = append(includes, include{node: n, offset: typed.Lines().At(0).Start, target: target, err: err})
+			return ast.WalkSkipChildren, nil
+
+		case *ast.Paragraph, *ast.TextBlock:
+			file, written, ok := embeddedNote(n, source)
+			offset, found := firstNodeOffset(n)
+			if !ok || !found {
+				return ast.WalkSkipChildren, nil
+			}
+			target, err := parseIncludeTarget(file)
+			target.raw = written
+			includes = append(includes, include{node: n, offset: offset, target: target, err: err})
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+
+	// Swapping nodes during the walk would cut it short.
+	for _, include := range includes {
+		parent := include.node.Parent()
+		if parent == nil {
+			continue
+		}
+
+		err := include.err
+		block := &includeBlock{}
+		path, content := "", ""
+		if err == nil {
+			path, content, err = t.include(include.target, baseDir)
+		}
+		// The include keeps where it starts, so it is cached and annotated
+		// like other blocks.
+		segments := text.NewSegments()
+		segments.Append(text.NewSegment(include.offset, include.offset))
+		block.SetLines(segments)
+		block.SetAttributeString(mdLineAttribute, strconv.Itoa(lines.line(include.offset)))
+		if err != nil {
+			block.SetAttributeString("class", "md-include md-include-error")
+			block.html = includeLabel(include.target, 0) +
+				`<div class="md-include-message">` + stdhtml.EscapeString(err.Error()) + "</div>"
+		} else {
+			block.SetAttributeString("class", "md-include")
+			handle := t.r.assets.handle(path)
+			t.files[handle] = path
+			block.SetAttributeString("data-include", handle)
+			block.html = includeLabel(include.target, max(include.target.start, 1)) +
+				`<div class="md-include-body">` + content + "</div>"
+		}
+		parent.ReplaceChild(parent, include.node, block)
+	}
+}
+
+// includeLabel renders the marker shown above included content. Given a
+// line, double-clicking it opens the included file there.
+func includeLabel(target includeTarget, line int) string {
+	var b strings.Builder
+	b.WriteString(`<div class="md-include-label"`)
+	if line > 0 {
+		b.WriteString(` ` + includeLineAttribute + `="` + strconv.Itoa(line) + `"`)
+	}
+	b.WriteString(`>`)
+	b.WriteString(stdhtml.EscapeString(target.raw))
+	b.WriteString(`</div>`)
+	return b.String()
+}
+
+// include renders the target, resolved against baseDir. It returns the
+// path of the included file and its content, whose lines refer to that
+// file.
+func (t *transclusion) include(target includeTarget, baseDir string) (string, string, error) {
+	path := target.path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+	real, err := filepath.EvalSymlinks(filepath.Clean(path))
+	if err != nil {
+		return "", "", fmt.Errorf("%s was not found", target.path)
+	}
+	info, err := os.Stat(real)
+	switch {
+	case err != nil:
+		return "", "", fmt.Errorf("%s was not found", target.path)
+	case info.IsDir():
+		return "", "", fmt.Errorf("%s is a directory", target.path)
+	case info.Size() > maxIncludeSize:
+		return "", "", fmt.Errorf("%s is too large to include", target.path)
+	case !withinAny(real, t.roots):
+		return "", "", fmt.Errorf("%s is outside the directories files may be included from", target.path)
+	}
+
+	if slices.Contains(t.chain, real) {
+		names := make([]string, 0, len(t.chain)+1)
+		for _, file := range t.chain[slices.Index(t.chain, real):] {
+			names = append(names, filepath.Base(file))
+		}
+		names = append(names, filepath.Base(real))
+		return "", "", fmt.Errorf("include cycle: %s", strings.Join(names, " → "))
+	}
+	if len(t.chain) > maxIncludeDepth {
+		return "", "", fmt.Errorf("includes are nested more than %d deep", maxIncludeDepth)
+	}
+
+	source, err := os.ReadFile(real)
+	if err != nil {
+		return "", "", fmt.Errorf("%s could not be read", target.path)
+	}
+	source, err = sliceLines(source, target.start, target.end)
+	if err != nil {
+		return "", "", fmt.Errorf("%s %w", target.path, err)
+	}
+
+	nested := &transclusion{r: t.r, roots: t.roots, assets: t.assets, files: t.files, chain: append(slices.Clip(t.chain), real)}
+	// Lines of the rendered content are numbered from the start of the
+	// included range. Included code is rendered inside a fence; its
+	// wrapper, whatever line it was given, maps to the start of the range.
+	if !target.markdown {
+		source = codeFence(source, target.info)
+	}
+	content, err := nested.render(source, real)
+	if err != nil {
+		return "", "", err
+	}
+
+	offset := max(target.start, 1) - 1
+	if !target.markdown {
+		_, first := blockKey([]byte(content))
+		offset = max(target.start, 1) - first
+	}
+	content = shiftLines(content, offset)
+	content = strings.ReplaceAll(content, string(mdLineMarker), includeLineAttribute+`="`)
+	return real, content, nil
+}
+
+// render renders included markdown the way the previewed document is
+// rendered, including the files it includes in turn.
+func (t *transclusion) render(source []byte, sourcePath string) (string, error) {
+	doc := t.r.md.Parser().Parse(text.NewReader(source))
+	t.transclude(doc, source, sourcePath)
+	decorateAST(doc, source, sourcePath, t.r.assetRegistrar(sourcePath, t.assets), t.r.isDiagramLanguage)
+
+	var buf bytes.Buffer
+	if err := t.r.md.Renderer().Render(&buf, source, doc); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// sliceLines returns lines start through end of source, or all of it when
+// start is zero.
+func sliceLines(source []byte, start, end int) ([]byte, error) {
+	if start == 0 {
+		return source, nil
+	}
+
+	lines := bytes.SplitAfter(source, []byte("\n"))
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	if start > len(lines) {
+		return nil, fmt.Errorf("has only %d lines", len(lines))
+	}
+	return bytes.Join(lines[start-1:min(end, len(lines))], nil), nil
+}
+
+// codeFence wraps code in a fence with the info string info, longer than
+// any fence inside the code.
+func codeFence(code []byte, info string) []byte {
+	longest := 0
+	for _, line := range bytes.Split(code, []byte("\n")) {
+		line = bytes.TrimLeft(line, " \t")
+		run := len(line) - len(bytes.TrimLeft(line, "`"))
+		longest = max(longest, run)
+	}
+	fence := strings.Repeat("`", max(3, longest+1))
+
+	var b bytes.Buffer
+	b.WriteString(fence + info + "\n")
+	b.Write(code)
+	if len(code) > 0 && code[len(code)-1] != '\n' {
+		b.WriteByte('\n')
+	}
+	b.WriteString(fence + "\n")
+	return b.Bytes()
+}
+
+// includeRenderer renders include blocks inside a container that marks
+// them as included.
+type includeRenderer struct{}
+
+func (includeRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindInclude, renderInclude)
+}
+
+func renderInclude(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	_, _ = w.WriteString("<div")
+	html.RenderAttributes(w, n, nil)
+	_, _ = w.WriteString(">")
+	_, _ = w.WriteString(n.(*includeBlock).html)
+	_, _ = w.WriteString("</div>\n")
+	return ast.WalkSkipChildren, nil
+}
@@ -0,0 +1,68 @@
+package render
+
+import (
+	"strings"
+
+	mathjax "github.com/litao91/goldmark-mathjax"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/util"
+)
+
+// mathRenderer renders the math nodes parsed by goldmark-mathjax to MathML,
+// in place of the extension's renderers that leave the TeX for MathJax to
+// typeset in the browser. The preview then needs no script from a CDN.
+type mathRenderer struct{}
+
+// mathRendererPriority places mathRenderer ahead of the extension's
+// renderers, registered at 501 and 502.
+const mathRendererPriority = 100
+
+func (mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(mathjax.KindMathBlock, renderMathBlock)
+	reg.Register(mathjax.KindInlineMath, renderInlineMath)
+}
+
+func renderMathBlock(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	var tex strings.Builder
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		segment := lines.At(i)
+		tex.Write(segment.Value(source))
+	}
+
+	_, _ = w.WriteString(`<div class="math-display"`)
+	html.RenderAttributes(w, n, nil)
+	_, _ = w.WriteString(`>`)
+	_, _ = w.WriteString(texToMathML(tex.String(), true))
+	_, _ = w.WriteString("</div>\n")
+	return ast.WalkSkipChildren, nil
+}
+
+func renderInlineMath(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	// Like the extension, join the lines of math wrapped across lines
+	// with spaces.
+	var tex strings.Builder
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		text, ok := c.(*ast.Text)
+		if !ok {
+			continue
+		}
+		tex.WriteString(strings.TrimSuffix(string(text.Segment.Value(source)), "\n"))
+		if c != n.LastChild() {
+			tex.WriteString(" ")
+		}
+	}
+
+	_, _ = w.WriteString(texToMathML(tex.String(), false))
+	return ast.WalkSkipChildren, nil
+}
@@ -0,0 +1,970 @@
+package render
+
+import (
+	stdhtml "html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// texToMathML converts a TeX formula to a MathML <math> element, so math
+// renders in the browser without a script. display selects block layout.
+//
+// It covers the subset of TeX that markdown notes use: scripts, fractions,
+// roots, symbols, delimiters, accents, fonts, text and the usual matrix and
+// alignment environments. Unknown commands and environments are shown as
+// errors in place, with their source.
+func texToMathML(tex string, display bool) string {
+	tex = strings.ToValidUTF8(tex, "\uFFFD")
+	p := &texParser{src: tex, display: display}
+	row := mrow(p.parseList(0)...)
+
+	var b strings.Builder
+	b.WriteString(`<math`)
+	if display {
+		b.WriteString(` display="block"`)
+	}
+	b.WriteString(`><semantics>`)
+	row.write(&b)
+	b.WriteString(`<annotation encoding="application/x-tex">`)
+	b.WriteString(stdhtml.EscapeString(strings.TrimSpace(tex)))
+	b.WriteString(`</annotation></semantics></math>`)
+	return b.String()
+}
+
+// mathNode is a MathML element. Token elements (mi, mn, mo, mtext) carry
+// text; the others carry children.
+type mathNode struct {
+	tag      string
+	attrs    [][2]string
+	text     string
+	children []*mathNode
+	// limits places scripts under and over the node in display mode, as
+	// for \sum and \lim.
+	limits bool
+	// function marks operator names such as \sin, which are followed by a
+	// thin space unless a parenthesis follows.
+	function bool
+}
+
+func mtoken(tag, text string, attrs ...[2]string) *mathNode {
+	return &mathNode{tag: tag, text: text, attrs: attrs}
+}
+
+func melement(tag string, children ...*mathNode) *mathNode {
+	if children == nil {
+		children = []*mathNode{}
+	}
+	return &mathNode{tag: tag, children: children}
+}
+
+// mrow groups nodes, returning a lone node as is.
+func mrow(children ...*mathNode) *mathNode {
+	if len(children) == 1 {
+		return children[0]
+	}
+	return melement("mrow", children...)
+}
+
+func mspace(width string) *mathNode {
+	return mtoken("mspace", "", [2]string{"width", width})
+}
+
+func (n *mathNode) attr(name, value string) *mathNode {
+	n.attrs = append(n.attrs, [2]string{name, value})
+	return n
+}
+
+func (n *mathNode) write(b *strings.Builder) {
+	b.WriteString("<")
+	b.WriteString(n.tag)
+	for _, attr := range n.attrs {
+		b.WriteString(" ")
+		b.WriteString(attr[0])
+		b.WriteString(`="`)
+		b.WriteString(stdhtml.EscapeString(attr[1]))
+		b.WriteString(`"`)
+	}
+	b.WriteString(">")
+	if n.children == nil {
+		b.WriteString(stdhtml.EscapeString(n.text))
+	}
+	for _, child := range n.children {
+		child.write(b)
+	}
+	b.WriteString("</")
+	b.WriteString(n.tag)
+	b.WriteString(">")
+}
+
+type texTokenKind int
+
+const (
+	texEOF texTokenKind = iota
+	texChar
+	texCommand
+)
+
+type texToken struct {
+	kind texTokenKind
+	// value is the character, or the command name without its backslash.
+	value string
+	// end is the offset just past the token.
+	end int
+}
+
+func (t texToken) is(kind texTokenKind, value string) bool {
+	return t.kind == kind && t.value == value
+}
+
+// Tokens that end a list of nodes, given to parseList. Any other closing
+// token met while parsing a list is unbalanced and skipped.
+const (
+	stopBrace = 1 << iota // }
+	stopRight             // \right
+	stopCell              // & \\ \end
+)
+
+// maxTexDepth bounds how deeply groups, command arguments and environments
+// nest. Formulas are converted on every edit, so a deeper one is shown as
+// an error rather than recursing further.
+const maxTexDepth = 64
+
+type texParser struct {
+	src     string
+	pos     int
+	display bool
+	// font is the mathvariant applied to letters and digits, set by
+	// \mathbf and friends.
+	font string
+	// depth counts the atoms being parsed, up to maxTexDepth.
+	depth int
+}
+
+func (p *texParser) peek() texToken {
+	pos := p.pos
+	for pos < len(p.src) {
+		r, size := utf8.DecodeRuneInString(p.src[pos:])
+		switch {
+		case unicode.IsSpace(r):
+			pos += size
+		case r == '%':
+			for pos < len(p.src) && p.src[pos] != '\n' {
+				pos++
+			}
+		case r == '\\':
+			start := pos + 1
+			end := start
+			for end < len(p.src) && isASCIILetter(p.src[end]) {
+				end++
+			}
+			if end == start && end < len(p.src) {
+				_, size := utf8.DecodeRuneInString(p.src[end:])
+				end += size
+			}
+			return texToken{kind: texCommand, value: p.src[start:end], end: end}
+		default:
+			return texToken{kind: texChar, value: string(r), end: pos + size}
+		}
+	}
+	return texToken{kind: texEOF, end: pos}
+}
+
+func (p *texParser) next() texToken {
+	tok := p.peek()
+	p.pos = tok.end
+	return tok
+}
+
+func isASCIILetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// rawGroup reads the text between open and its matching close verbatim, as
+// for \text{...} or the optional argument of \sqrt. It returns false, and
+// reads nothing, when the next character is not open.
+func (p *texParser) rawGroup(open, close byte) (string, bool) {
+	pos := p.pos
+	for pos < len(p.src) && (p.src[pos] == ' ' || p.src[pos] == '\t' || p.src[pos] == '\n') {
+		pos++
+	}
+	if pos >= len(p.src) || p.src[pos] != open {
+		return "", false
+	}
+
+	depth := 0
+	for i := pos; i < len(p.src); i++ {
+		switch p.src[i] {
+		case '\\':
+			i++
+		case open:
+			depth++
+		case close:
+			depth--
+			if depth == 0 {
+				p.pos = i + 1
+				return p.src[pos+1 : i], true
+			}
+		}
+	}
+	p.pos = len(p.src)
+	return p.src[pos+1:], true
+}
+
+// sub parses src, such as a raw optional argument, with the state of p.
+func (p *texParser) sub(src string) *mathNode {
+	q := &texParser{src: src, display: p.display, font: p.font, depth: p.depth}
+	return mrow(q.parseList(0)...)
+}
+
+// parseList parses nodes up to the end of the source or a token in stop,
+// which is left for the caller.
+func (p *texParser) parseList(stop int) []*mathNode {
+	var nodes []*mathNode
+	for {
+		tok := p.peek()
+		switch {
+		case tok.kind == texEOF:
+			return nodes
+		case tok.is(texChar, "}"):
+			if stop&stopBrace != 0 {
+				return nodes
+			}
+			p.next()
+			continue
+		case tok.is(texCommand, "right"):
+			if stop&stopRight != 0 {
+				return nodes
+			}
+			p.next()
+			p.delimiter()
+			continue
+		case tok.is(texChar, "&"), tok.is(texCommand, "\\"), tok.is(texCommand, "end"):
+			if stop&stopCell != 0 {
+				return nodes
+			}
+			p.next()
+			if tok.value == "end" {
+				p.rawGroup('{', '}')
+			}
+			continue
+		}
+
+		var base *mathNode
+		if !tok.is(texChar, "^") && !tok.is(texChar, "_") && !tok.is(texChar, "'") {
+			base = p.parseAtom()
+			if base == nil {
+				continue
+			}
+		}
+		node := p.parseScripts(base)
+		nodes = append(nodes, node)
+
+		if base != nil && base.function {
+			next := p.peek()
+			if !next.is(texChar, "(") && !next.is(texCommand, "left") && next.kind != texEOF {
+				nodes = append(nodes, mspace("0.1667em"))
+			}
+		}
+	}
+}
+
+// parseScripts attaches the sub- and superscripts and primes that follow
+// base, which is nil for scripts without a base.
+func (p *texParser) parseScripts(base *mathNode) *mathNode {
+	var sub, sup *mathNode
+	primes := ""
+	limits := base != nil && base.limits && p.display
+
+scripts:
+	for {
+		tok := p.peek()
+		switch {
+		case tok.is(texChar, "^") && sup == nil:
+			p.next()
+			sup = p.parseArg()
+		case tok.is(texChar, "_") && sub == nil:
+			p.next()
+			sub = p.parseArg()
+		case tok.is(texChar, "'") && sup == nil:
+			p.next()
+			primes += "′"
+		case tok.is(texCommand, "limits"):
+			p.next()
+			limits = true
+		case tok.is(texCommand, "nolimits"):
+			p.next()
+			limits = false
+		default:
+			break scripts
+		}
+	}
+
+	if primes != "" {
+		if sup == nil {
+			sup = mtoken("mo", primes)
+		} else {
+			sup = mrow(mtoken("mo", primes), sup)
+		}
+	}
+	if base == nil {
+		if sub == nil && sup == nil {
+			return melement("mrow")
+		}
+		base = melement("mrow")
+	}
+
+	switch {
+	case sub != nil && sup != nil && limits:
+		return melement("munderover", base, sub, sup)
+	case sub != nil && sup != nil:
+		return melement("msubsup", base, sub, sup)
+	case sub != nil && limits:
+		return melement("munder", base, sub)
+	case sub != nil:
+		return melement("msub", base, sub)
+	case sup != nil && limits:
+		return melement("mover", base, sup)
+	case sup != nil:
+		return melement("msup", base, sup)
+	}
+	return base
+}
+
+// parseArg parses the argument of a command or script: a group, a single
+// character or a command with its own arguments.
+func (p *texParser) parseArg() *mathNode {
+	tok := p.peek()
+	switch {
+	case tok.kind == texEOF, tok.is(texChar, "}"), tok.is(texChar, "&"):
+		return melement("mrow")
+	case tok.kind == texChar && tok.value != "{":
+		p.next()
+		r, _ := utf8.DecodeRuneInString(tok.value)
+		if unicode.IsDigit(r) {
+			return p.number(tok.value)
+		}
+		return p.character(r)
+	}
+	if node := p.parseAtom(); node != nil {
+		return node
+	}
+	return melement("mrow")
+}
+
+// parseGroup parses the rest of a group whose { was read.
+func (p *texParser) parseGroup() *mathNode {
+	nodes := p.parseList(stopBrace)
+	if p.peek().is(texChar, "}") {
+		p.next()
+	}
+	if len(nodes) == 0 {
+		return melement("mrow")
+	}
+	return mrow(nodes...)
+}
+
+// parseAtom parses the node starting at the next token. It returns nil for
+// commands that produce nothing, such as \displaystyle.
+func (p *texParser) parseAtom() *mathNode {
+	if p.depth >= maxTexDepth {
+		return p.tooDeep()
+	}
+	p.depth++
+	defer func() { p.depth-- }()
+
+	tok := p.next()
+	switch tok.kind {
+	case texChar:
+		if tok.value == "{" {
+			return p.parseGroup()
+		}
+		r, _ := utf8.DecodeRuneInString(tok.value)
+		if unicode.IsDigit(r) || r == '.' && p.nextIsDigit() {
+			return p.number(tok.value + p.digits())
+		}
+		return p.character(r)
+	case texCommand:
+		return p.command(tok.value)
+	}
+	return nil
+}
+
+// tooDeep shows the rest of the source, which nests deeper than
+// maxTexDepth, as an error.
+func (p *texParser) tooDeep() *mathNode {
+	rest := strings.TrimSpace(p.src[p.pos:])
+	p.pos = len(p.src)
+	return melement("merror", mtoken("mtext", rest))
+}
+
+func (p *texParser) nextIsDigit() bool {
+	return p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9'
+}
+
+// digits reads the rest of a number, which may have one decimal point.
+func (p *texParser) digits() string {
+	start := p.pos
+	for p.pos < len(p.src) && (p.nextIsDigit() || p.src[p.pos] == '.' && p.pos+1 < len(p.src) && p.src[p.pos+1] >= '0' && p.src[p.pos+1] <= '9') {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *texParser) number(digits string) *mathNode {
+	if p.font == "" || p.font == "normal" || p.font == "italic" {
+		return mtoken("mn", digits)
+	}
+	var b strings.Builder
+	for _, r := range digits {
+		b.WriteRune(styledRune(p.font, r))
+	}
+	return mtoken("mn", b.String())
+}
+
+// character converts a character outside of a command.
+func (p *texParser) character(r rune) *mathNode {
+	switch r {
+	case '-':
+		return mtoken("mo", "−")
+	case '*':
+		return mtoken("mo", "∗")
+	case '~':
+		return mspace("0.333em")
+	case '\'':
+		return mtoken("mo", "′")
+	case '(', ')', '[', ']':
+		// Plain parentheses keep their size; \left and \right stretch.
+		return mtoken("mo", string(r)).attr("stretchy", "false")
+	}
+	if unicode.IsLetter(r) {
+		return p.identifier(string(r))
+	}
+	if unicode.IsDigit(r) {
+		return p.number(string(r))
+	}
+	return mtoken("mo", string(r))
+}
+
+// identifier returns an mi for a letter in the current font.
+func (p *texParser) identifier(letter string) *mathNode {
+	switch p.font {
+	case "", "italic":
+		return mtoken("mi", letter)
+	case "normal":
+		return mtoken("mi", letter).attr("mathvariant", "normal")
+	}
+	r, _ := utf8.DecodeRuneInString(letter)
+	return mtoken("mi", string(styledRune(p.font, r)))
+}
+
+// withFont parses the argument of a font command such as \mathbf.
+func (p *texParser) withFont(font string) *mathNode {
+	saved := p.font
+	p.font = font
+	defer func() { p.font = saved }()
+	return p.parseArg()
+}
+
+// text reads the argument of \text and friends verbatim.
+func (p *texParser) text() *mathNode {
+	raw, ok := p.rawGroup('{', '}')
+	if !ok {
+		tok := p.next()
+		raw = tok.value
+	}
+	raw = strings.NewReplacer(`\{`, "{", `\}`, "}", `\_`, "_", `\%`, "%", `\$`, "$", `\&`, "&", `\ `, " ", `~`, " ").Replace(raw)
+	node := mtoken("mtext", raw)
+	if style, ok := texTextStyles[p.font]; ok {
+		node.attr("style", style)
+	}
+	return node
+}
+
+// delimiter reads the delimiter after \left, \right, \big and the like.
+// It returns nil for the empty delimiter ".".
+func (p *texParser) delimiter() *mathNode {
+	tok := p.next()
+	switch tok.kind {
+	case texChar:
+		if tok.value == "." {
+			return nil
+		}
+		if tok.value == "<" {
+			return mtoken("mo", "⟨")
+		}
+		if tok.value == ">" {
+			return mtoken("mo", "⟩")
+		}
+		return mtoken("mo", tok.value)
+	case texCommand:
+		if sym, ok := texOperators[tok.value]; ok {
+			return mtoken("mo", sym)
+		}
+		if sym, ok := texIdentifiers[tok.value]; ok {
+			return mtoken("mo", sym)
+		}
+	}
+	return nil
+}
+
+// command converts a command and its arguments.
+func (p *texParser) command(name string) *mathNode {
+	if sym, ok := texIdentifiers[name]; ok {
+		node := mtoken("mi", sym)
+		if r, _ := utf8.DecodeRuneInString(sym); unicode.IsUpper(r) {
+			node.attr("mathvariant", "normal")
+		}
+		return node
+	}
+	if sym, ok := texOperators[name]; ok {
+		return mtoken("mo", sym)
+	}
+	if op, ok := texLargeOperators[name]; ok {
+		node := mtoken("mo", op.symbol)
+		node.limits = op.limits
+		return node
+	}
+	if texFunctions[name] {
+		node := mtoken("mi", name)
+		node.function = true
+		return node
+	}
+	if label, ok := texLimitFunctions[name]; ok {
+		node := mtoken("mi", label)
+		node.function = true
+		node.limits = true
+		return node
+	}
+	if width, ok := texSpaces[name]; ok {
+		return mspace(width)
+	}
+	if font, ok := texFonts[name]; ok {
+		return p.withFont(font)
+	}
+	if accent, ok := texAccents[name]; ok {
+		base := p.parseArg()
+		mark := mtoken("mo", accent.symbol)
+		if !accent.stretchy {
+			mark.attr("stretchy", "false")
+		}
+		if accent.under {
+			return melement("munder", base, mark).attr("accentunder", "true")
+		}
+		return melement("mover", base, mark).attr("accent", "true")
+	}
+	if size, ok := texBigDelimiters[name]; ok {
+		delim := p.delimiter()
+		if delim == nil {
+			return nil
+		}
+		return delim.attr("minsize", size).attr("maxsize", size).attr("stretchy", "true")
+	}
+
+	switch name {
+	case "frac", "cfrac":
+		return melement("mfrac", p.parseArg(), p.parseArg())
+	case "dfrac", "tfrac":
+		frac := melement("mfrac", p.parseArg(), p.parseArg())
+		style := "true"
+		if name == "tfrac" {
+			style = "false"
+		}
+		return melement("mstyle", frac).attr("displaystyle", style)
+	case "binom", "dbinom", "tbinom":
+		frac := melement("mfrac", p.parseArg(), p.parseArg()).attr("linethickness", "0")
+		return mrow(mtoken("mo", "("), frac, mtoken("mo", ")"))
+	case "sqrt":
+		if index, ok := p.rawGroup('[', ']'); ok {
+			degree := p.sub(index)
+			return melement("mroot", p.parseArg(), degree)
+		}
+		return melement("msqrt", p.parseArg())
+	case "left":
+		open := p.delimiter()
+		body := p.parseList(stopRight | stopBrace)
+		var closing *mathNode
+		if p.peek().is(texCommand, "right") {
+			p.next()
+			closing = p.delimiter()
+		}
+		row := melement("mrow")
+		if open != nil {
+			row.children = append(row.children, open.attr("fence", "true").attr("stretchy", "true"))
+		}
+		row.children = append(row.children, body...)
+		if closing != nil {
+			row.children = append(row.children, closing.attr("fence", "true").attr("stretchy", "true"))
+		}
+		return row
+	case "middle":
+		if delim := p.delimiter(); delim != nil {
+			return delim.attr("stretchy", "true")
+		}
+		return nil
+	case "text", "textrm", "textnormal", "mbox", "hbox":
+		return p.text()
+	case "textbf", "textit", "texttt", "textsf":
+		saved := p.font
+		p.font = texFonts["math"+strings.TrimPrefix(name, "text")]
+		defer func() { p.font = saved }()
+		return p.text()
+	case "operatorname":
+		limits := false
+		if p.peek().is(texChar, "*") {
+			p.next()
+			limits = true
+		}
+		raw, ok := p.rawGroup('{', '}')
+		if !ok {
+			raw = p.next().value
+		}
+		node := mtoken("mi", strings.TrimSpace(raw))
+		if utf8.RuneCountInString(node.text) == 1 {
+			node.attr("mathvariant", "normal")
+		}
+		node.function = true
+		node.limits = limits
+		return node
+	case "not":
+		return negate(p.parseArg())
+	case "overset", "stackrel":
+		over := p.parseArg()
+		return melement("mover", p.parseArg(), over)
+	case "underset":
+		under := p.parseArg()
+		return melement("munder", p.parseArg(), under)
+	case "phantom":
+		return melement("mphantom", p.parseArg())
+	case "boxed":
+		return melement("mrow", p.parseArg()).attr("style", "border: 1px solid currentColor; padding: 0.2em")
+	case "textcolor":
+		start := p.pos - len(name) - 1
+		color, _ := p.rawGroup('{', '}')
+		color = strings.TrimSpace(color)
+		body := p.parseArg()
+		if !isTexColor(color) {
+			return melement("merror", mtoken("mtext", p.src[start:p.pos]))
+		}
+		return melement("mstyle", body).attr("mathcolor", color)
+	case "pmod":
+		return mrow(mspace("1em"), mtoken("mo", "("), mtoken("mi", "mod"), mspace("0.333em"), p.parseArg(), mtoken("mo", ")"))
+	case "bmod":
+		return mtoken("mo", "mod")
+	case "begin":
+		start := p.pos - len(name) - 1
+		env, _ := p.rawGroup('{', '}')
+		env = strings.TrimSpace(env)
+		table := p.environment(env)
+		if !texEnvironments[strings.TrimSuffix(env, "*")] {
+			return melement("merror", mtoken("mtext", p.src[start:p.pos]))
+		}
+		return table
+	case "tag", "label", "color":
+		p.rawGroup('{', '}')
+		return nil
+	case "displaystyle", "textstyle", "scriptstyle", "nonumber", "notag", "mathstrut", "limits", "nolimits":
+		return nil
+	}
+	return p.unsupported(name)
+}
+
+// unsupported shows an unknown command, together with the arguments that
+// directly follow it, as an error.
+func (p *texParser) unsupported(name string) *mathNode {
+	start := p.pos - len(name) - 1
+	p.rawGroup('[', ']')
+	for {
+		if _, ok := p.rawGroup('{', '}'); !ok {
+			break
+		}
+	}
+	return melement("merror", mtoken("mtext", p.src[start:p.pos]))
+}
+
+// isTexColor reports whether color is a color name, such as "red", or a
+// hex color, such as "#f80" or "#ff8800". Other xcolor expressions, such as
+// "red!50", are not supported.
+func isTexColor(color string) bool {
+	hex, ok := strings.CutPrefix(color, "#")
+	if !ok {
+		return color != "" && strings.IndexFunc(color, func(r rune) bool {
+			return r > unicode.MaxASCII || !isASCIILetter(byte(r))
+		}) < 0
+	}
+	switch len(hex) {
+	case 3, 4, 6, 8:
+	default:
+		return false
+	}
+	return strings.IndexFunc(hex, func(r rune) bool {
+		return !strings.ContainsRune("0123456789abcdefABCDEF", r)
+	}) < 0
+}
+
+// negate draws a slash through a relation, as \not does.
+func negate(node *mathNode) *mathNode {
+	if node.tag != "mo" {
+		return node
+	}
+	if negated, ok := texNegations[node.text]; ok {
+		node.text = negated
+	} else {
+		node.text += "̸"
+	}
+	return node
+}
+
+// environment parses the body of \begin{env} up to its \end into a table.
+func (p *texParser) environment(env string) *mathNode {
+	name := strings.TrimSuffix(env, "*")
+	if name == "array" || name == "alignat" {
+		p.rawGroup('{', '}')
+	}
+
+	table := melement("mtable")
+	row := melement("mtr")
+	for {
+		cell := p.parseList(stopCell | stopBrace)
+		row.children = append(row.children, melement("mtd", mrow(cell...)))
+		// A trailing \\ leaves an empty last row.
+		emptyRow := len(row.children) == 1 && len(cell) == 0
+
+		tok := p.peek()
+		if tok.is(texChar, "&") {
+			p.next()
+			continue
+		}
+		if tok.is(texCommand, "\\") {
+			p.next()
+			p.rawGroup('[', ']')
+			table.children = append(table.children, row)
+			row = melement("mtr")
+			continue
+		}
+		if tok.is(texCommand, "end") {
+			p.next()
+			p.rawGroup('{', '}')
+		}
+		if !emptyRow {
+			table.children = append(table.children, row)
+		}
+		break
+	}
+
+	var open, closing string
+	switch name {
+	case "pmatrix":
+		open, closing = "(", ")"
+	case "bmatrix":
+		open, closing = "[", "]"
+	case "Bmatrix":
+		open, closing = "{", "}"
+	case "vmatrix":
+		open, closing = "|", "|"
+	case "Vmatrix":
+		open, closing = "‖", "‖"
+	case "cases":
+		open = "{"
+		table.attr("columnalign", "left left")
+	case "aligned", "align", "alignat", "split", "eqnarray":
+		table.attr("columnalign", "right left right left right left")
+		table.attr("columnspacing", "0em 1em")
+		table.attr("displaystyle", "true")
+	case "gather", "gathered":
+		table.attr("displaystyle", "true")
+	case "smallmatrix":
+		table = melement("mstyle", table).attr("scriptlevel", "1")
+	}
+
+	if open == "" && closing == "" {
+		return table
+	}
+	row = melement("mrow")
+	if open != "" {
+		row.children = append(row.children, mtoken("mo", open).attr("fence", "true").attr("stretchy", "true"))
+	}
+	row.children = append(row.children, table)
+	if closing != "" {
+		row.children = append(row.children, mtoken("mo", closing).attr("fence", "true").attr("stretchy", "true"))
+	}
+	return row
+}
+
+// styledRune maps a Latin letter or digit to its Mathematical Alphanumeric
+// Symbols form, which browsers render without font support for
+// mathvariant.
+func styledRune(font string, r rune) rune {
+	if special, ok := texStyledExceptions[font][r]; ok {
+		return special
+	}
+	bases, ok := texStyledBases[font]
+	if !ok {
+		return r
+	}
+	switch {
+	case r >= 'A' && r <= 'Z':
+		return bases[0] + r - 'A'
+	case r >= 'a' && r <= 'z':
+		return bases[1] + r - 'a'
+	case r >= '0' && r <= '9' && bases[2] != 0:
+		return bases[2] + r - '0'
+	}
+	return r
+}
+
+// texStyledBases holds the code points of A, a and 0 in each font.
+var texStyledBases = map[string][3]rune{
+	"bold":          {0x1D400, 0x1D41A, 0x1D7CE},
+	"bold-italic":   {0x1D468, 0x1D482, 0},
+	"script":        {0x1D49C, 0x1D4B6, 0},
+	"fraktur":       {0x1D504, 0x1D51E, 0},
+	"double-struck": {0x1D538, 0x1D552, 0x1D7D8},
+	"sans-serif":    {0x1D5A0, 0x1D5BA, 0x1D7E2},
+	"monospace":     {0x1D670, 0x1D68A, 0x1D7F6},
+}
+
+// texStyledExceptions lists the letters that were in Unicode before the
+// Mathematical Alphanumeric Symbols block and are left out of it.
+var texStyledExceptions = map[string]map[rune]rune{
+	"script": {
+		'B': 'ℬ', 'E': 'ℰ', 'F': 'ℱ', 'H': 'ℋ', 'I': 'ℐ', 'L': 'ℒ', 'M': 'ℳ', 'R': 'ℛ',
+		'e': 'ℯ', 'g': 'ℊ', 'o': 'ℴ',
+	},
+	"fraktur":       {'C': 'ℭ', 'H': 'ℌ', 'I': 'ℑ', 'R': 'ℜ', 'Z': 'ℨ'},
+	"double-struck": {'C': 'ℂ', 'H': 'ℍ', 'N': 'ℕ', 'P': 'ℙ', 'Q': 'ℚ', 'R': 'ℝ', 'Z': 'ℤ'},
+}
+
+var texFonts = map[string]string{
+	"mathrm":     "normal",
+	"mathup":     "normal",
+	"mathit":     "italic",
+	"mathbf":     "bold",
+	"boldsymbol": "bold-italic",
+	"bm":         "bold-italic",
+	"mathbb":     "double-struck",
+	"mathcal":    "script",
+	"mathscr":    "script",
+	"mathfrak":   "fraktur",
+	"mathsf":     "sans-serif",
+	"mathtt":     "monospace",
+}
+
+// texTextStyles sets the font of \textbf and friends. Browsers only
+// honour mathvariant on single letters.
+var texTextStyles = map[string]string{
+	"bold":       "font-weight: bold",
+	"italic":     "font-style: italic",
+	"sans-serif": "font-family: sans-serif",
+	"monospace":  "font-family: monospace",
+}
+
+var texIdentifiers = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ", "varepsilon": "ε",
+	"zeta": "ζ", "eta": "η", "theta": "θ", "vartheta": "ϑ", "iota": "ι", "kappa": "κ",
+	"lambda": "λ", "mu": "μ", "nu": "ν", "xi": "ξ", "omicron": "ο", "pi": "π", "varpi": "ϖ",
+	"rho": "ρ", "varrho": "ϱ", "sigma": "σ", "varsigma": "ς", "tau": "τ", "upsilon": "υ",
+	"phi": "ϕ", "varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π",
+	"Sigma": "Σ", "Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+	"infty": "∞", "partial": "∂", "nabla": "∇", "emptyset": "∅", "varnothing": "∅",
+	"hbar": "ℏ", "ell": "ℓ", "Re": "ℜ", "Im": "ℑ", "aleph": "ℵ", "wp": "℘", "imath": "ı", "jmath": "ȷ",
+	"angle": "∠", "triangle": "△", "square": "□", "Box": "□", "degree": "°",
+	"top": "⊤", "bot": "⊥", "dagger": "†", "ddagger": "‡", "S": "§", "P": "¶",
+	"$": "$", "#": "#", "%": "%", "_": "_",
+}
+
+var texOperators = map[string]string{
+	"pm": "±", "mp": "∓", "times": "×", "div": "÷", "cdot": "⋅", "ast": "∗", "star": "⋆",
+	"circ": "∘", "bullet": "∙", "oplus": "⊕", "ominus": "⊖", "otimes": "⊗", "oslash": "⊘",
+	"odot": "⊙", "cap": "∩", "cup": "∪", "wedge": "∧", "land": "∧", "vee": "∨", "lor": "∨",
+	"setminus": "∖", "backslash": "\\", "amalg": "⨿", "uplus": "⊎", "sqcap": "⊓", "sqcup": "⊔",
+	"leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠", "ne": "≠", "leqslant": "⩽", "geqslant": "⩾",
+	"equiv": "≡", "approx": "≈", "cong": "≅", "sim": "∼", "simeq": "≃", "propto": "∝",
+	"ll": "≪", "gg": "≫", "prec": "≺", "succ": "≻", "preceq": "⪯", "succeq": "⪰", "doteq": "≐",
+	"subset": "⊂", "supset": "⊃", "subseteq": "⊆", "supseteq": "⊇", "subsetneq": "⊊", "supsetneq": "⊋",
+	"in": "∈", "notin": "∉", "ni": "∋", "mid": "∣", "nmid": "∤", "parallel": "∥", "perp": "⊥",
+	"vdash": "⊢", "dashv": "⊣", "models": "⊨", "asymp": "≍", "bowtie": "⋈",
+	"to": "→", "rightarrow": "→", "leftarrow": "←", "gets": "←", "leftrightarrow": "↔",
+	"Rightarrow": "⇒", "Leftarrow": "⇐", "Leftrightarrow": "⇔", "implies": "⟹", "impliedby": "⟸",
+	"iff": "⟺", "mapsto": "↦", "longrightarrow": "⟶", "longleftarrow": "⟵", "longmapsto": "⟼",
+	"Longrightarrow": "⟹", "Longleftarrow": "⟸", "longleftrightarrow": "⟷", "Longleftrightarrow": "⟺",
+	"uparrow": "↑", "downarrow": "↓", "updownarrow": "↕", "Uparrow": "⇑", "Downarrow": "⇓",
+	"hookrightarrow": "↪", "hookleftarrow": "↩", "rightharpoonup": "⇀", "leftharpoonup": "↼",
+	"nearrow": "↗", "searrow": "↘", "swarrow": "↙", "nwarrow": "↖", "leadsto": "⇝",
+	"forall": "∀", "exists": "∃", "nexists": "∄", "neg": "¬", "lnot": "¬",
+	"therefore": "∴", "because": "∵", "colon": ":",
+	"ldots": "…", "dots": "…", "dotsc": "…", "dotsb": "⋯", "cdots": "⋯", "vdots": "⋮", "ddots": "⋱",
+	"prime": "′", "langle": "⟨", "rangle": "⟩", "lceil": "⌈", "rceil": "⌉", "lfloor": "⌊", "rfloor": "⌋",
+	"lvert": "|", "rvert": "|", "vert": "|", "lVert": "‖", "rVert": "‖", "Vert": "‖", "|": "‖",
+	"{": "{", "}": "}", "lbrace": "{", "rbrace": "}", "lbrack": "[", "rbrack": "]", "&": "&",
+	"ulcorner": "⌜", "urcorner": "⌝", "llcorner": "⌞", "lrcorner": "⌟",
+}
+
+// texNegations maps relations to their negated forms for \not.
+var texNegations = map[string]string{
+	"=": "≠", "<": "≮", ">": "≯", "≤": "≰", "≥": "≱", "∈": "∉", "∋": "∌", "⊂": "⊄", "⊃": "⊅",
+	"⊆": "⊈", "⊇": "⊉", "≡": "≢", "∼": "≁", "≃": "≄", "≈": "≉", "≅": "≇", "∣": "∤", "∥": "∦",
+}
+
+type texLargeOperator struct {
+	symbol string
+	limits bool
+}
+
+var texLargeOperators = map[string]texLargeOperator{
+	"sum": {"∑", true}, "prod": {"∏", true}, "coprod": {"∐", true},
+	"bigcup": {"⋃", true}, "bigcap": {"⋂", true}, "bigvee": {"⋁", true}, "bigwedge": {"⋀", true},
+	"bigoplus": {"⨁", true}, "bigotimes": {"⨂", true}, "bigodot": {"⨀", true}, "biguplus": {"⨄", true},
+	"bigsqcup": {"⨆", true}, "int": {"∫", false}, "iint": {"∬", false}, "iiint": {"∭", false}, "oint": {"∮", false},
+}
+
+// texFunctions are operator names set upright with scripts to their side.
+var texFunctions = map[string]bool{
+	"sin": true, "cos": true, "tan": true, "cot": true, "sec": true, "csc": true,
+	"arcsin": true, "arccos": true, "arctan": true, "sinh": true, "cosh": true, "tanh": true, "coth": true,
+	"log": true, "ln": true, "lg": true, "exp": true, "arg": true, "deg": true, "dim": true,
+	"hom": true, "ker": true,
+}
+
+// texLimitFunctions are operator names that take limits in display mode,
+// mapped to their label.
+var texLimitFunctions = map[string]string{
+	"lim": "lim", "liminf": "lim inf", "limsup": "lim sup", "max": "max", "min": "min",
+	"sup": "sup", "inf": "inf", "det": "det", "gcd": "gcd", "Pr": "Pr", "argmax": "arg max", "argmin": "arg min",
+}
+
+var texSpaces = map[string]string{
+	",": "0.1667em", ":": "0.2222em", ">": "0.2222em", ";": "0.2778em", "!": "-0.1667em",
+	" ": "0.333em", "quad": "1em", "qquad": "2em", "enspace": "0.5em", "thinspace": "0.1667em",
+}
+
+type texAccent struct {
+	symbol   string
+	stretchy bool
+	under    bool
+}
+
+var texAccents = map[string]texAccent{
+	"hat": {"^", false, false}, "widehat": {"^", true, false}, "check": {"ˇ", false, false},
+	"tilde": {"~", false, false}, "widetilde": {"~", true, false}, "bar": {"¯", false, false},
+	"overline": {"‾", true, false}, "vec": {"→", false, false}, "overrightarrow": {"→", true, false},
+	"overleftarrow": {"←", true, false}, "dot": {"˙", false, false}, "ddot": {"¨", false, false},
+	"acute": {"´", false, false}, "grave": {"`", false, false}, "breve": {"˘", false, false},
+	"overbrace": {"⏞", true, false}, "underbrace": {"⏟", true, true}, "underline": {"_", true, true},
+}
+
+// texEnvironments are the environments environment lays out.
+var texEnvironments = map[string]bool{
+	"matrix": true, "pmatrix": true, "bmatrix": true, "Bmatrix": true, "vmatrix": true, "Vmatrix": true,
+	"smallmatrix": true, "array": true, "cases": true, "aligned": true, "align": true, "alignat": true,
+	"split": true, "eqnarray": true, "gather": true, "gathered": true, "equation": true,
+}
+
+// texBigDelimiters maps \big and friends to the size of their delimiter.
+var texBigDelimiters = map[string]string{
+	"big": "1.2em", "bigl": "1.2em", "bigr": "1.2em", "bigm": "1.2em",
+	"Big": "1.8em", "Bigl": "1.8em", "Bigr": "1.8em", "Bigm": "1.8em",
+	"bigg": "2.4em", "biggl": "2.4em", "biggr": "2.4em", "biggm": "2.4em",
+	"Bigg": "3em", "Biggl": "3em", "Biggr": "3em", "Biggm": "3em",
+}
@@ -0,0 +1,147 @@
+package render
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+// mathBody strips the <math> wrapper and the TeX annotation from the
+// output of texToMathML.
+func mathBody(t *testing.T, out string) string {
+	t.Helper()
+
+	body, ok := strings.CutPrefix(out, "<math><semantics>")
+	if !ok {
+		t.Fatalf("output does not start with <math><semantics>: %s", out)
+	}
+	i := strings.Index(body, `<annotation encoding="application/x-tex">`)
+	if i < 0 {
+		t.Fatalf("output has no TeX annotation: %s", out)
+	}
+	return body[:i]
+}
+
+func TestTexToMathML(t *testing.T) {
+	tests := []struct {
+		name string
+		tex  string
+		want string
+	}{
+		{"fraction", `\frac{a}{b}`, `<mfrac><mi>a</mi><mi>b</mi></mfrac>`},
+		{"nested fraction", `\frac{1}{\frac{x}{2}}`, `<mfrac><mn>1</mn><mfrac><mi>x</mi><mn>2</mn></mfrac></mfrac>`},
+		{"superscript", `x^2`, `<msup><mi>x</mi><mn>2</mn></msup>`},
+		{"subscript", `x_i`, `<msub><mi>x</mi><mi>i</mi></msub>`},
+		{"sub and superscript", `x_i^2`, `<msubsup><mi>x</mi><mi>i</mi><mn>2</mn></msubsup>`},
+		{"grouped script", `e^{i\pi}`, `<msup><mi>e</mi><mrow><mi>i</mi><mi>π</mi></mrow></msup>`},
+		{"large operator", `\sum_{i=1}^n i`, `<mrow><msubsup><mo>∑</mo><mrow><mi>i</mi><mo>=</mo><mn>1</mn></mrow><mi>n</mi></msubsup><mi>i</mi></mrow>`},
+		{"square root", `\sqrt{x}`, `<msqrt><mi>x</mi></msqrt>`},
+		{"nth root", `\sqrt[3]{x}`, `<mroot><mi>x</mi><mn>3</mn></mroot>`},
+		{"matrix", `\begin{matrix} a & b \\ c & d \end{matrix}`,
+			`<mtable><mtr><mtd><mi>a</mi></mtd><mtd><mi>b</mi></mtd></mtr><mtr><mtd><mi>c</mi></mtd><mtd><mi>d</mi></mtd></mtr></mtable>`},
+		{"pmatrix", `\begin{pmatrix} a \\ b \end{pmatrix}`,
+			`<mrow><mo fence="true" stretchy="true">(</mo><mtable><mtr><mtd><mi>a</mi></mtd></mtr><mtr><mtd><mi>b</mi></mtd></mtr></mtable><mo fence="true" stretchy="true">)</mo></mrow>`},
+		{"cases", `\begin{cases} 1 & x \\ 0 & y \end{cases}`,
+			`<mrow><mo fence="true" stretchy="true">{</mo><mtable columnalign="left left"><mtr><mtd><mn>1</mn></mtd><mtd><mi>x</mi></mtd></mtr><mtr><mtd><mn>0</mn></mtd><mtd><mi>y</mi></mtd></mtr></mtable></mrow>`},
+		{"aligned", `\begin{aligned} a &= b \\ c &= d \\ \end{aligned}`,
+			`<mtable columnalign="right left right left right left" columnspacing="0em 1em" displaystyle="true"><mtr><mtd><mi>a</mi></mtd><mtd><mrow><mo>=</mo><mi>b</mi></mrow></mtd></mtr><mtr><mtd><mi>c</mi></mtd><mtd><mrow><mo>=</mo><mi>d</mi></mrow></mtd></mtr></mtable>`},
+		{"hat", `\hat{x}`, `<mover accent="true"><mi>x</mi><mo stretchy="false">^</mo></mover>`},
+		{"vector", `\vec{v}`, `<mover accent="true"><mi>v</mi><mo stretchy="false">→</mo></mover>`},
+		{"bar", `\bar{x}`, `<mover accent="true"><mi>x</mi><mo stretchy="false">¯</mo></mover>`},
+		{"escaped relation", `a < b`, `<mrow><mi>a</mi><mo>&lt;</mo><mi>b</mi></mrow>`},
+		{"named color", `\textcolor{red}{x}`, `<mstyle mathcolor="red"><mi>x</mi></mstyle>`},
+		{"hex color", `\textcolor{#f80}{x}`, `<mstyle mathcolor="#f80"><mi>x</mi></mstyle>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mathBody(t, texToMathML(tt.tex, false)); got != tt.want {
+				t.Errorf("texToMathML(%q)\n got %s\nwant %s", tt.tex, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTexToMathMLUnsupported(t *testing.T) {
+	tests := []struct {
+		name string
+		tex  string
+		want string
+	}{
+		{"command with argument", `\xrightarrow{f}`, `<merror><mtext>\xrightarrow{f}</mtext></merror>`},
+		{"command with optional argument", `\xrightarrow[g]{f} x`,
+			`<mrow><merror><mtext>\xrightarrow[g]{f}</mtext></merror><mi>x</mi></mrow>`},
+		{"escaped argument", `\foo{<b>&}`, `<merror><mtext>\foo{&lt;b&gt;&amp;}</mtext></merror>`},
+		{"environment", `\begin{tikzcd} a \end{tikzcd}`, `<merror><mtext>\begin{tikzcd} a \end{tikzcd}</mtext></merror>`},
+		{"color expression", `\textcolor{red!50}{x}`, `<merror><mtext>\textcolor{red!50}{x}</mtext></merror>`},
+		{"color with markup", `\textcolor{red" onclick="x}{y}`, `<merror><mtext>\textcolor{red&#34; onclick=&#34;x}{y}</mtext></merror>`},
+		{"too deep", strings.Repeat("{", maxTexDepth) + `x`, `<merror><mtext>x</mtext></merror>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mathBody(t, texToMathML(tt.tex, false)); got != tt.want {
+				t.Errorf("texToMathML(%q)\n got %s\nwant %s", tt.tex, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTexToMathMLNesting(t *testing.T) {
+	for _, tex := range []string{
+		strings.Repeat("{", 100000),
+		strings.Repeat(`\frac`, 100000),
+		strings.Repeat(`\sqrt[`, 100000),
+		strings.Repeat(`\left(`, 100000),
+		strings.Repeat(`\begin{matrix}`, 100000),
+		strings.Repeat("x^{", 100000),
+	} {
+		out := texToMathML(tex, false)
+		if !strings.Contains(out, "<merror>") {
+			t.Errorf("texToMathML(%.20q...) was not cut off", tex)
+		}
+	}
+}
+
+func TestTexToMathMLDisplay(t *testing.T) {
+	out := texToMathML(`x`, true)
+	if !strings.HasPrefix(out, `<math display="block">`) {
+		t.Errorf("display math does not use block layout: %s", out)
+	}
+}
+
+func FuzzTexToMathML(f *testing.F) {
+	for _, seed := range []string{
+		`\frac{a}{b}`, `x_i^2`, `\sqrt[3]{x}`, `\left( \frac{1}{2} \right)`,
+		`\begin{pmatrix} a & b \\ c & d \end{pmatrix}`, `\hat{x} + \vec{v}`,
+		`\text{if } x > 0`, `\mathbf{A}^{-1}`, `\xrightarrow{f}`, `{`, `}`, `x^`, `\`,
+	} {
+		f.Add(seed, false)
+	}
+
+	f.Fuzz(func(t *testing.T, tex string, display bool) {
+		out := texToMathML(tex, display)
+
+		// Control characters pass through as text, which HTML accepts but
+		// XML does not.
+		if strings.ContainsFunc(tex, func(r rune) bool {
+			return r < ' ' && r != '\t' && r != '\n' && r != '\r' || r == 0xFFFE || r == 0xFFFF
+		}) {
+			return
+		}
+
+		// Otherwise the output must be well-formed markup.
+		dec := xml.NewDecoder(strings.NewReader(out))
+		dec.Strict = true
+		for {
+			_, err := dec.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("texToMathML(%q) is not well-formed: %v\n%s", tex, err, out)
+			}
+		}
+	})
+}
@@ -4,9 +4,9 @@ package render
 import (
 	"bytes"
 	_ "embed"
-	"encoding/base64"
 	stdhtml "html"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -18,6 +18,7 @@ import (
 	"github.com/yuin/goldmark/extension"
 	extensionast "github.com/yuin/goldmark/extension/ast"
 	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
 	"github.com/yuin/goldmark/renderer/html"
 	"github.com/yuin/goldmark/text"
 	"github.com/yuin/goldmark/util"
@@ -30,7 +31,10 @@ const mdLineAttribute = "data-md-line"
 
 // Renderer wraps Goldmark with the plugin's markdown extensions and options.
 type Renderer struct {
-	md goldmark.Markdown
+	md       goldmark.Markdown
+	assets   *assetTable
+	blocks   *blockCache
+	external *externalRenderers
 }
 
 // TOCItem represents a single heading entry for the preview table of contents.
@@ -45,6 +49,14 @@ type TOCItem struct {
 type Document struct {
 	HTML string
 	TOC  []TOCItem
+	// Blocks is HTML split into its top-level blocks.
+	Blocks []Block
+	// Assets maps the handles of local files referenced by the document,
+	// as used in AssetPrefix URLs, to their resolved paths.
+	Assets map[string]string
+	// Includes maps the handles of included files, as found in the
+	// data-include attribute of their content, to their resolved paths.
+	Includes map[string]string
 }
 
 //go:embed page.html
@@ -52,6 +64,7 @@ var pageTemplate string
 
 // NewRenderer builds a renderer configured for GitHub-style markdown preview.
 func NewRenderer() *Renderer {
+	external := newExternalRenderers()
 	md := goldmark.New(
 		goldmark.WithExtensions(
 			extension.Footnote,
@@ -80,9 +93,36 @@ func NewRenderer() *Renderer {
 		goldmark.WithRendererOptions(
 			// html.WithHardWraps(),
 			html.WithUnsafe(),
+			renderer.WithNodeRenderers(
+				util.Prioritized(mathRenderer{}, mathRendererPriority),
+				util.Prioritized(&diagramRenderer{external: external}, 200),
+				util.Prioritized(includeRenderer{}, 200),
+			),
 		),
 	)
-	return &Renderer{md: md}
+	return &Renderer{md: md, assets: newAssetTable(), blocks: newBlockCache(), external: external}
+}
+
+// SetAssetRoots sets directories that local images may be loaded from in
+// addition to the document's directory and its project root.
+func (r *Renderer) SetAssetRoots(roots []string) {
+	r.assets.setRoots(roots)
+}
+
+// SetFenceRenderers maps fence languages to commands, such as "dot -Tsvg",
+// that read the contents of a fence on stdin and print the SVG shown in
+// its place. Commands are split on whitespace and run in the document's
+// directory.
+func (r *Renderer) SetFenceRenderers(commands map[string]string) {
+	if r.external.set(commands) {
+		r.blocks.clear()
+	}
+}
+
+// isDiagramLanguage reports whether fences of language are drawn as
+// diagrams rather than highlighted.
+func (r *Renderer) isDiagramLanguage(language string) bool {
+	return diagramLanguages[language] || r.external.handles(language)
 }
 
 type previewWikilinkResolver struct{}
@@ -132,14 +172,49 @@ func (r *Renderer) ConvertFragmentWithSourcePath(source []byte, sourcePath strin
 // HTML fragment together with TOC metadata.
 func (r *Renderer) ConvertDocumentWithSourcePath(source []byte, sourcePath string) (Document, error) {
 	doc := r.md.Parser().Parse(text.NewReader(source))
-	toc := decorateAST(doc, source, sourcePath)
 
-	var buf bytes.Buffer
-	if err := r.md.Renderer().Render(&buf, source, doc); err != nil {
+	assets := make(map[string]string)
+	includes := &transclusion{
+		r:      r,
+		roots:  r.assets.documentRoots(sourcePath),
+		assets: assets,
+		files:  make(map[string]string),
+	}
+	if sourcePath != "" {
+		real, err := filepath.EvalSymlinks(sourcePath)
+		if err != nil {
+			real = filepath.Clean(sourcePath)
+		}
+		includes.chain = []string{real}
+	}
+	includes.transclude(doc, source, sourcePath)
+	toc := decorateAST(doc, source, sourcePath, r.assetRegistrar(sourcePath, assets), r.isDiagramLanguage)
+
+	blocks, err := r.renderBlocks(doc, source, sourcePath)
+	if err != nil {
 		return Document{}, err
 	}
 
-	return Document{HTML: buf.String(), TOC: toc}, nil
+	var html strings.Builder
+	for _, block := range blocks {
+		html.WriteString(block.HTML)
+	}
+
+	return Document{HTML: html.String(), TOC: toc, Blocks: blocks, Assets: assets, Includes: includes.files}, nil
+}
+
+// assetRegistrar returns the function decorateAST registers the local
+// files referenced by the document at sourcePath with, recording their
+// handles in assets.
+func (r *Renderer) assetRegistrar(sourcePath string, assets map[string]string) func(string) (string, bool) {
+	roots := r.assets.documentRoots(sourcePath)
+	return func(path string) (string, bool) {
+		handle, real, ok := r.assets.register(path, roots)
+		if ok {
+			assets[handle] = real
+		}
+		return handle, ok
+	}
 }
 
 // RenderPage returns a complete HTML page with the markdown rendered inside.
@@ -159,15 +234,21 @@ func (r *Renderer) RenderShell() string {
 }
 
 // decorateAST walks the AST once and applies render metadata.
-// It attaches data-md-line to block-level elements for cursor sync and,
-// when sourcePath is available, rewrites local image destinations to /@mdfs/.
-func decorateAST(doc ast.Node, source []byte, sourcePath string) []TOCItem {
+// It attaches data-md-line to block-level elements for cursor sync and
+// rewrites local image destinations to AssetPrefix URLs with the handles
+// issued by registerAsset. Images that registerAsset refuses keep their
+// destination and do not load. Fences whose language isDiagram accepts
+// are replaced with diagram blocks; other fences get the attributes of
+// their info string.
+func decorateAST(doc ast.Node, source []byte, sourcePath string, registerAsset func(string) (string, bool), isDiagram func(string) bool) []TOCItem {
 	baseDir := ""
 	if sourcePath != "" {
 		baseDir = filepath.Dir(sourcePath)
 	}
 
 	toc := make([]TOCItem, 0, 16)
+	lines := newLineIndex(source)
+	var diagrams []*ast.FencedCodeBlock
 
 	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
 		if !entering {
@@ -177,13 +258,21 @@ func decorateAST(doc ast.Node, source []byte, sourcePath string) []TOCItem {
 		if shouldAnnotateNode(n) {
 			offset, ok := firstNodeOffset(n)
 			if ok {
-				n.SetAttributeString(mdLineAttribute, strconv.Itoa(offsetToLine(source, offset)))
+				n.SetAttributeString(mdLineAttribute, strconv.Itoa(lines.line(offset)))
 			}
 		}
 
+		if fence, ok := n.(*ast.FencedCodeBlock); ok {
+			if isDiagram(fenceLanguage(fence, source)) {
+				diagrams = append(diagrams, fence)
+				return ast.WalkSkipChildren, nil
+			}
+			setCodeBlockAttributes(fence, source)
+		}
+
 		heading, ok := n.(*ast.Heading)
 		if ok {
-			if item, ok := tocItemFromHeading(heading, source); ok {
+			if item, ok := tocItemFromHeading(heading, source, lines); ok {
 				toc = append(toc, item)
 			}
 		}
@@ -206,33 +295,39 @@ func decorateAST(doc ast.Node, source []byte, sourcePath string) []TOCItem {
 			strings.HasPrefix(lowerDest, "file://") ||
 			strings.HasPrefix(lowerDest, "//") ||
 			strings.HasPrefix(lowerDest, "#") ||
-			strings.HasPrefix(lowerDest, "/@mdfs/") {
+			strings.HasPrefix(lowerDest, AssetPrefix) {
 			return ast.WalkContinue, nil
 		}
 
-		if filepath.IsAbs(rawDest) {
-			img.Destination = []byte("/@mdfs/" + base64.RawURLEncoding.EncodeToString([]byte(filepath.Clean(rawDest))))
-			img.SetAttributeString("loading", "lazy")
-			img.SetAttributeString("decoding", "async")
-			return ast.WalkContinue, nil
+		resolved := filepath.Clean(rawDest)
+		if !filepath.IsAbs(rawDest) {
+			if baseDir == "" {
+				return ast.WalkContinue, nil
+			}
+			resolved = filepath.Clean(filepath.Join(baseDir, rawDest))
 		}
 
-		if baseDir == "" {
+		handle, ok := registerAsset(resolved)
+		if !ok {
 			return ast.WalkContinue, nil
 		}
 
-		resolved := filepath.Clean(filepath.Join(baseDir, rawDest))
-		img.Destination = []byte("/@mdfs/" + base64.RawURLEncoding.EncodeToString([]byte(resolved)))
+		img.Destination = []byte(AssetPrefix + handle)
 		img.SetAttributeString("loading", "lazy")
 		img.SetAttributeString("decoding", "async")
 
 		return ast.WalkContinue, nil
 	})
 
+	// Swapping nodes during the walk would cut it short.
+	for _, fence := range diagrams {
+		replaceDiagramFence(fence, fenceLanguage(fence, source), baseDir)
+	}
+
 	return toc
 }
 
-func tocItemFromHeading(heading *ast.Heading, source []byte) (TOCItem, bool) {
+func tocItemFromHeading(heading *ast.Heading, source []byte, lines lineIndex) (TOCItem, bool) {
 	if heading == nil {
 		return TOCItem{}, false
 	}
@@ -249,7 +344,7 @@ func tocItemFromHeading(heading *ast.Heading, source []byte) (TOCItem, bool) {
 
 	line := 0
 	if offset, ok := firstNodeOffset(heading); ok {
-		line = offsetToLine(source, offset)
+		line = lines.line(offset)
 	}
 
 	return TOCItem{
@@ -300,7 +395,8 @@ func shouldAnnotateNode(n ast.Node) bool {
 		// ast.KindList,
 		ast.KindListItem,
 		ast.KindThematicBreak,
-		extensionast.KindTable:
+		extensionast.KindTable,
+		mathjax.KindMathBlock:
 		return true
 	default:
 		return false
@@ -331,24 +427,30 @@ func firstNodeOffset(n ast.Node) (int, bool) {
 	return 0, false
 }
 
-// offsetToLine converts a byte offset to a 1-based line number.
-// It counts how many newline characters appear before the offset position
-// The offset is clamped to the valid range [0, len(source)].
-func offsetToLine(source []byte, offset int) int {
-	if offset < 0 {
-		offset = 0
-	}
+// lineIndex maps byte offsets of a source to line numbers. It holds the
+// offset of every newline, so a lookup does not rescan the source.
+type lineIndex []int
 
-	if offset > len(source) {
-		offset = len(source)
+func newLineIndex(source []byte) lineIndex {
+	index := make(lineIndex, 0, bytes.Count(source, []byte{'\n'}))
+	for i, c := range source {
+		if c == '\n' {
+			index = append(index, i)
+		}
 	}
+	return index
+}
 
-	return bytes.Count(source[:offset], []byte{'\n'}) + 1
+// line converts a byte offset to a 1-based line number by counting the
+// newlines before it.
+func (l lineIndex) line(offset int) int {
+	return sort.SearchInts(l, offset) + 1
 }
 
 // renderHighlightedCodeWrapper wraps syntax-highlighted code blocks in a div
 // with the data-md-line attribute. This is a custom wrapper renderer used by
 // the goldmark-highlighting extension to preserve line metadata for code blocks.
+// A title from the info string is shown as a header above the code.
 func renderHighlightedCodeWrapper(w util.BufWriter, context highlighting.CodeBlockContext, entering bool) {
 	line, ok := highlightedCodeLine(context)
 	if !ok {
@@ -372,12 +474,28 @@ func renderHighlightedCodeWrapper(w util.BufWriter, context highlighting.CodeBlo
 		}
 
 		_, _ = w.WriteString(`>`)
+
+		if title := codeTitle(context); title != "" {
+			_, _ = w.WriteString(`<div class="code-title">`)
+			_, _ = w.WriteString(stdhtml.EscapeString(title))
+			_, _ = w.WriteString(`</div>`)
+		}
 		return
 	}
 
 	_, _ = w.WriteString("</div>")
 }
 
+// codeTitle returns the title given in a code block's info string.
+func codeTitle(context highlighting.CodeBlockContext) string {
+	v, ok := context.Attributes().GetString(codeTitleAttribute)
+	if !ok {
+		return ""
+	}
+	title, _ := v.(string)
+	return title
+}
+
 // highlightedCodeLine extracts the line number attribute from a code block's
 // rendering context. This attribute was set during the annotateBlockSourceLines
 // walk and needs to be transferred to the wrapper div.
@@ -0,0 +1,30 @@
+package render
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// VendorPrefix is the URL path under which the browser runtimes bundled
+// with the host are served.
+const VendorPrefix = "/@vendor/"
+
+// MermaidRuntime is the name of the Mermaid runtime in Vendor.
+const MermaidRuntime = "mermaid.min.js"
+
+//go:embed vendor
+var vendorFiles embed.FS
+
+// Vendor holds the bundled browser runtimes, such as the Mermaid runtime
+// diagrams are drawn with. They are committed under internal/render/vendor
+// and pinned in its SHA256SUMS; without one, diagrams show their source and
+// the self-check reports it missing.
+var Vendor fs.FS = vendorRoot()
+
+func vendorRoot() fs.FS {
+	root, err := fs.Sub(vendorFiles, "vendor")
+	if err != nil {
+		panic(err)
+	}
+	return root
+}
@@ -0,0 +1,164 @@
+package httpserver
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// tokenCookiePrefix names the cookies that carry session tokens. The
+// cookie name embeds the token itself, so previews of several servers on
+// the same host never overwrite each other's cookies.
+const tokenCookiePrefix = "glm-"
+
+// access is the role a request was authorized for.
+type access int
+
+const (
+	accessNone access = iota
+	// accessViewer may watch a shared session but not act on the editor.
+	accessViewer
+	// accessEditor is the editor's own browser.
+	accessEditor
+)
+
+// sharedKey marks requests that arrived on the share listener.
+type sharedKey struct{}
+
+// sessionInfo is what the HTTP handlers know about a preview session.
+type sessionInfo struct {
+	filename string
+	token    string
+	// viewerToken grants read-only access while the session is shared.
+	viewerToken string
+	// assets maps the asset handles of the latest render to file paths.
+	assets map[string]string
+	// viewers are the read-only browsers currently connected.
+	viewers map[*browserClient]struct{}
+}
+
+// NewToken returns a random URL-safe token.
+func NewToken() string {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// tokenEqual compares tokens in constant time.
+func tokenEqual(a, b string) bool {
+	return a != "" && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// sessionAccess returns the role r carries for session, from the token
+// query parameter or a cookie set by handleDocument. Requests on the share
+// listener are only ever granted viewer access.
+func (m *PreviewServer) sessionAccess(r *http.Request, session string) access {
+	m.sessionsMu.Lock()
+	info, ok := m.sessions[session]
+	var token, viewerToken string
+	if ok {
+		token, viewerToken = info.token, info.viewerToken
+	}
+	m.sessionsMu.Unlock()
+	if !ok {
+		return accessNone
+	}
+
+	if !isShared(r) && requestHasToken(r, session, token) {
+		return accessEditor
+	}
+	if requestHasToken(r, session, viewerToken) {
+		return accessViewer
+	}
+	return accessNone
+}
+
+func requestHasToken(r *http.Request, session string, token string) bool {
+	if token == "" {
+		return false
+	}
+	if tokenEqual(r.URL.Query().Get("token"), token) {
+		return true
+	}
+	cookie, err := r.Cookie(tokenCookiePrefix + token)
+	return err == nil && cookie.Value == session
+}
+
+// isShared reports whether r arrived on the share listener.
+func isShared(r *http.Request) bool {
+	shared, _ := r.Context().Value(sharedKey{}).(bool)
+	return shared
+}
+
+// indexAuthorized reports whether r carries the server token. The index is
+// never served to viewers.
+func (m *PreviewServer) indexAuthorized(r *http.Request) bool {
+	if isShared(r) {
+		return false
+	}
+
+	m.mu.Lock()
+	token := m.token
+	m.mu.Unlock()
+
+	return tokenEqual(r.URL.Query().Get("token"), token)
+}
+
+// lookupAsset resolves an asset handle for r. Only assets of sessions whose
+// page r comes from are visible. Images are requested without query
+// tokens, so only cookies count here.
+func (m *PreviewServer) lookupAsset(r *http.Request, handle string) (string, bool) {
+	m.sessionsMu.Lock()
+	defer m.sessionsMu.Unlock()
+
+	for _, cookie := range r.Cookies() {
+		token, ok := strings.CutPrefix(cookie.Name, tokenCookiePrefix)
+		if !ok {
+			continue
+		}
+
+		info, known := m.sessions[cookie.Value]
+		if !known {
+			continue
+		}
+		if !tokenEqual(token, info.viewerToken) && (isShared(r) || !tokenEqual(token, info.token)) {
+			continue
+		}
+		if path, ok := info.assets[handle]; ok {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// setSessionCookie remembers the session token in the browser, so assets
+// and reloads work without repeating it in every URL.
+func setSessionCookie(w http.ResponseWriter, session string, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     tokenCookiePrefix + token,
+		Value:    session,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// sameOrigin rejects websocket upgrades started by pages of other origins.
+// Requests without an Origin header do not come from a browser page.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
@@ -0,0 +1,136 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	testSession     = "doc"
+	testToken       = "editor-token"
+	testViewerToken = "viewer-token"
+)
+
+// newTestServer returns a preview server that knows a single shared
+// session and has not been started.
+func newTestServer(t *testing.T) *PreviewServer {
+	t.Helper()
+
+	m := NewPreviewServer(ListenConfig{Host: "127.0.0.1"}, "<html></html>")
+	m.sessions[testSession] = &sessionInfo{
+		token:       testToken,
+		viewerToken: testViewerToken,
+		viewers:     make(map[*browserClient]struct{}),
+	}
+	return m
+}
+
+func TestSessionAccess(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		cookie  *http.Cookie
+		shared  bool
+		session string
+		want    access
+	}{
+		{"editor token", "/?token=" + testToken, nil, false, testSession, accessEditor},
+		{"editor cookie", "/", &http.Cookie{Name: tokenCookiePrefix + testToken, Value: testSession}, false, testSession, accessEditor},
+		{"viewer token", "/?token=" + testViewerToken, nil, false, testSession, accessViewer},
+		{"viewer token on share listener", "/?token=" + testViewerToken, nil, true, testSession, accessViewer},
+		{"editor token on share listener", "/?token=" + testToken, nil, true, testSession, accessNone},
+		{"no token", "/", nil, false, testSession, accessNone},
+		{"wrong token", "/?token=guess", nil, false, testSession, accessNone},
+		{"empty token", "/?token=", nil, false, testSession, accessNone},
+		{"cookie of another session", "/", &http.Cookie{Name: tokenCookiePrefix + testToken, Value: "other"}, false, testSession, accessNone},
+		{"unknown session", "/?token=" + testToken, nil, false, "other", accessNone},
+	}
+
+	m := newTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.cookie != nil {
+				r.AddCookie(tt.cookie)
+			}
+			if tt.shared {
+				r = r.WithContext(context.WithValue(r.Context(), sharedKey{}, true))
+			}
+			if got := m.sessionAccess(r, tt.session); got != tt.want {
+				t.Errorf("sessionAccess() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSameOrigin(t *testing.T) {
+	tests := []struct {
+		name   string
+		host   string
+		origin string
+		want   bool
+	}{
+		{"no origin", "127.0.0.1:8090", "", true},
+		{"same origin", "127.0.0.1:8090", "http://127.0.0.1:8090", true},
+		{"same origin in other case", "localhost:8090", "http://LOCALHOST:8090", true},
+		{"other host", "127.0.0.1:8090", "http://evil.example", false},
+		{"other port", "127.0.0.1:8090", "http://127.0.0.1:9000", false},
+		{"opaque origin", "127.0.0.1:8090", "null", false},
+		{"malformed origin", "127.0.0.1:8090", "http://%zz", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+			r.Host = tt.host
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := sameOrigin(r); got != tt.want {
+				t.Errorf("sameOrigin(%q) on %s = %v, want %v", tt.origin, tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleWSRejectsUpgrade(t *testing.T) {
+	m := newTestServer(t)
+	srv := httptest.NewServer(http.HandlerFunc(m.handleWS))
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	tests := []struct {
+		name   string
+		query  string
+		origin string
+		want   int
+	}{
+		{"cross-origin", "?doc=" + testSession + "&token=" + testToken, "http://evil.example", http.StatusForbidden},
+		{"no token", "?doc=" + testSession, srv.URL, http.StatusForbidden},
+		{"wrong token", "?doc=" + testSession + "&token=guess", srv.URL, http.StatusForbidden},
+		{"unknown session", "?doc=other&token=" + testToken, srv.URL, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{"Origin": {tt.origin}}
+			conn, resp, err := websocket.DefaultDialer.Dial(wsURL+tt.query, header)
+			if err == nil {
+				conn.Close()
+				t.Fatal("upgrade succeeded")
+			}
+			if resp == nil {
+				t.Fatalf("upgrade failed without a response: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
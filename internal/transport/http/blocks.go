@@ -0,0 +1,95 @@
+package httpserver
+
+import (
+	"strconv"
+	"strings"
+
+	"go-live-markdown/internal/contracts"
+)
+
+// sessionBlock is a block of the latest render of a session together with
+// the ID browsers know it by.
+type sessionBlock struct {
+	id string
+	contracts.Block
+}
+
+// newBlockID issues a block ID that is unique within the session.
+func (s *sessionState) newBlockID() string {
+	s.blockSeq++
+	return strconv.FormatUint(s.blockSeq, 36)
+}
+
+// joinBlocks renders the full HTML of a session, marking where each block
+// starts so later patches can address it.
+func joinBlocks(blocks []sessionBlock) string {
+	var b strings.Builder
+	for _, block := range blocks {
+		b.WriteString("<!--b:")
+		b.WriteString(block.id)
+		b.WriteString("-->")
+		b.WriteString(block.HTML)
+	}
+	return b.String()
+}
+
+// diffBlocks matches a new render against the previous blocks of a session.
+// Edits are local, so unchanged blocks are only looked for at the start,
+// where they must not have moved, and at the end, where they must all have
+// moved by the same number of lines. Matched blocks keep their IDs. It
+// reports false when nothing matched and a full render is cheaper.
+func diffBlocks(s *sessionState, blocks []contracts.Block) ([]sessionBlock, contracts.PatchMessage, bool) {
+	prev := s.blocks
+
+	prefix := 0
+	for prefix < len(prev) && prefix < len(blocks) &&
+		prev[prefix].Key == blocks[prefix].Key && prev[prefix].Line == blocks[prefix].Line {
+		prefix++
+	}
+
+	shift, shifted := 0, false
+	suffix := 0
+	for suffix < len(prev)-prefix && suffix < len(blocks)-prefix {
+		old, cur := prev[len(prev)-1-suffix], blocks[len(blocks)-1-suffix]
+		if old.Key != cur.Key {
+			break
+		}
+		if old.Line != 0 && cur.Line != 0 {
+			if !shifted {
+				shift, shifted = cur.Line-old.Line, true
+			} else if cur.Line-old.Line != shift {
+				break
+			}
+		}
+		suffix++
+	}
+
+	next := make([]sessionBlock, 0, len(blocks))
+	var patch contracts.PatchMessage
+	for i, block := range blocks {
+		switch {
+		case i < prefix:
+			next = append(next, sessionBlock{id: prev[i].id, Block: block})
+		case i >= len(blocks)-suffix:
+			old := prev[len(prev)-(len(blocks)-i)]
+			next = append(next, sessionBlock{id: old.id, Block: block})
+		default:
+			id := s.newBlockID()
+			next = append(next, sessionBlock{id: id, Block: block})
+			patch.Insert = append(patch.Insert, contracts.PatchBlock{ID: id, HTML: block.HTML})
+		}
+	}
+
+	if prefix+suffix == 0 {
+		return next, patch, false
+	}
+
+	if prefix > 0 {
+		patch.After = prev[prefix-1].id
+	}
+	for _, old := range prev[prefix : len(prev)-suffix] {
+		patch.Remove = append(patch.Remove, old.id)
+	}
+	patch.Shift = shift
+	return next, patch, true
+}
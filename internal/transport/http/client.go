@@ -0,0 +1,253 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-live-markdown/internal/contracts"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// clientQueueSize bounds the messages waiting for a single browser.
+	clientQueueSize = 16
+	// writeWait bounds a single write to a browser.
+	writeWait = 10 * time.Second
+	// pongWait is how long a browser may stay silent before it is
+	// considered dead, e.g. after the laptop went to sleep.
+	pongWait = 30 * time.Second
+	// pingPeriod must be shorter than pongWait.
+	pingPeriod = pongWait / 2
+	// maxInboundSize bounds a single message read from the browser.
+	maxInboundSize = 64 << 10
+)
+
+// clientConn is the connection a browser client is written to: a websocket
+// or, as a fallback, a Server-Sent Events stream.
+type clientConn interface {
+	// write sends a single JSON-encoded message, bounded by writeWait, and
+	// reports whether it was compressed.
+	write(data []byte) (compressed bool, err error)
+	// ping keeps the connection alive and detects dead peers.
+	ping() error
+	close() error
+}
+
+// browserClient is a browser connected to a preview session. Its messages
+// are written by a dedicated goroutine from a bounded queue, so a slow or
+// dead tab never stalls the run loop or the other tabs of a session.
+type browserClient struct {
+	session string
+	conn    clientConn
+
+	// viewer marks read-only browsers of a shared session.
+	viewer  bool
+	addr    string
+	browser string
+	since   time.Time
+
+	metrics *metricsRecorder
+
+	queue chan any
+	quit  chan struct{}
+	once  sync.Once
+
+	// latestRev is the revision of the newest render or patch queued for
+	// the client, latestFullRev that of the newest full render. Queued
+	// messages of older revisions are superseded.
+	latestRev     atomic.Uint64
+	latestFullRev atomic.Uint64
+}
+
+func newBrowserClient(session string, conn clientConn, metrics *metricsRecorder) *browserClient {
+	return &browserClient{
+		session: session,
+		conn:    conn,
+		since:   time.Now(),
+		metrics: metrics,
+		queue:   make(chan any, clientQueueSize),
+		quit:    make(chan struct{}),
+	}
+}
+
+// enqueue schedules a message without blocking. When the queue is full, a
+// render replaces everything queued before it; any other message means the
+// browser stopped reading, so it is disconnected and catches up with the
+// latest state when the page reconnects.
+func (c *browserClient) enqueue(msg any) bool {
+	select {
+	case <-c.quit:
+		return false
+	default:
+	}
+
+	render, isRender := msg.(contracts.RenderMessage)
+	if isRender {
+		c.latestRev.Store(render.Rev)
+		c.latestFullRev.Store(render.Rev)
+	}
+	if patch, ok := msg.(contracts.PatchMessage); ok {
+		c.latestRev.Store(patch.Rev)
+	}
+
+	select {
+	case c.queue <- msg:
+		return true
+	default:
+	}
+
+	if isRender {
+		c.discardQueued()
+		select {
+		case c.queue <- msg:
+			return true
+		default:
+		}
+	}
+
+	slog.Warn("browser is not keeping up; disconnecting it", "session", c.session, "remote", c.addr)
+	c.close()
+	return false
+}
+
+// enqueueRender schedules a new revision of the document. A patch only
+// applies on top of the revision the browser is at, so browsers that are
+// at another one, or whose queue is full, get the full render instead.
+// Only runLoop enqueues, so the queue cannot fill up in between.
+func (c *browserClient) enqueueRender(full contracts.RenderMessage, patch *contracts.PatchMessage) bool {
+	if patch != nil && c.latestRev.Load() == patch.BaseRev && len(c.queue) < cap(c.queue) {
+		return c.enqueue(*patch)
+	}
+	return c.enqueue(full)
+}
+
+// discardQueued drops every queued message.
+func (c *browserClient) discardQueued() {
+	for {
+		select {
+		case <-c.queue:
+		default:
+			return
+		}
+	}
+}
+
+// superseded reports whether a queued message belongs to a render that a
+// newer one has replaced. Patches build on each other, so only a full
+// render supersedes them.
+func (c *browserClient) superseded(msg any) bool {
+	latest := c.latestRev.Load()
+	full := c.latestFullRev.Load()
+	switch m := msg.(type) {
+	case contracts.RenderMessage:
+		return m.Rev < full
+	case contracts.PatchMessage:
+		return m.Rev < full
+	case contracts.CursorMessage:
+		return m.Rev < latest
+	case contracts.ScrollMessage:
+		return m.Rev < latest
+	}
+	return false
+}
+
+// writeLoop writes queued messages and keepalive pings until the client
+// is closed. Every write is bounded by writeWait, so a half-open
+// connection is detected instead of blocking forever.
+func (c *browserClient) writeLoop() {
+	ping := time.NewTicker(pingPeriod)
+	defer ping.Stop()
+
+	for {
+		select {
+		case msg := <-c.queue:
+			if c.superseded(msg) {
+				continue
+			}
+			data, err := json.Marshal(msg)
+			if err != nil {
+				continue
+			}
+			compressed, err := c.conn.write(data)
+			if err != nil {
+				slog.Debug("write to browser failed", "session", c.session, "remote", c.addr, "err", err)
+				c.close()
+				return
+			}
+			c.metrics.recordMessage(msg, len(data), compressed)
+		case <-ping.C:
+			if err := c.conn.ping(); err != nil {
+				slog.Debug("browser stopped answering", "session", c.session, "remote", c.addr, "err", err)
+				c.close()
+				return
+			}
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// transport names the kind of connection the client uses.
+func (c *browserClient) transport() string {
+	if _, ok := c.conn.(*sseConn); ok {
+		return "sse"
+	}
+	return "websocket"
+}
+
+// close disconnects the client. It is safe to call more than once.
+func (c *browserClient) close() {
+	c.once.Do(func() {
+		close(c.quit)
+		_ = c.conn.close()
+	})
+}
+
+// wsConn is a websocket connection to a browser. compress is set when the
+// browser negotiated permessage-deflate.
+type wsConn struct {
+	conn     *websocket.Conn
+	compress bool
+}
+
+func newWSConn(conn *websocket.Conn, compress bool) *wsConn {
+	conn.SetReadLimit(maxInboundSize)
+	_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	return &wsConn{conn: conn, compress: compress}
+}
+
+// write compresses large messages, typically renders. Chroma classes and
+// MathJax markup shrink to a fraction of their size.
+func (w *wsConn) write(data []byte) (bool, error) {
+	compress := w.compress && len(data) >= compressThreshold
+	w.conn.EnableWriteCompression(compress)
+	_ = w.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return compress, w.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (w *wsConn) ping() error {
+	_ = w.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return w.conn.WriteMessage(websocket.PingMessage, nil)
+}
+
+// read returns the next message from the browser. Browsers answer pings
+// automatically, so a read that times out means the peer is gone.
+func (w *wsConn) read() ([]byte, error) {
+	_, msg, err := w.conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	_ = w.conn.SetReadDeadline(time.Now().Add(pongWait))
+	return msg, nil
+}
+
+func (w *wsConn) close() error {
+	return w.conn.Close()
+}
@@ -0,0 +1,115 @@
+package httpserver
+
+import (
+	"reflect"
+	"testing"
+
+	"go-live-markdown/internal/contracts"
+)
+
+// fakeConn is a client connection that records whether it was closed.
+type fakeConn struct {
+	closed bool
+}
+
+func (c *fakeConn) write(data []byte) (bool, error) { return false, nil }
+func (c *fakeConn) ping() error                     { return nil }
+func (c *fakeConn) close() error {
+	c.closed = true
+	return nil
+}
+
+func TestEnqueueFullQueue(t *testing.T) {
+	render := func(rev uint64) any {
+		return contracts.RenderMessage{Type: contracts.MessageTypeRender, Rev: rev}
+	}
+	cursor := func(rev uint64) any {
+		return contracts.CursorMessage{Type: contracts.MessageTypeCursor, Rev: rev}
+	}
+
+	tests := []struct {
+		name string
+		// fill is queued until the queue is full.
+		fill any
+		msg  any
+		// wantQueued is how many messages are queued afterwards.
+		wantQueued       int
+		wantDisconnected bool
+	}{
+		{"render replaces queued renders", render(1), render(2), 1, false},
+		{"render replaces queued cursors", cursor(1), render(2), 1, false},
+		{"cursor disconnects", render(1), cursor(2), clientQueueSize, true},
+		{"patch disconnects", render(1), contracts.PatchMessage{Type: contracts.MessageTypePatch, Rev: 2, BaseRev: 1}, clientQueueSize, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &fakeConn{}
+			c := newBrowserClient(testSession, conn, newMetricsRecorder())
+			for range clientQueueSize {
+				if !c.enqueue(tt.fill) {
+					t.Fatal("enqueue failed before the queue was full")
+				}
+			}
+
+			ok := c.enqueue(tt.msg)
+			if ok == tt.wantDisconnected {
+				t.Errorf("enqueue() = %v, want %v", ok, !tt.wantDisconnected)
+			}
+			if conn.closed != tt.wantDisconnected {
+				t.Errorf("disconnected = %v, want %v", conn.closed, tt.wantDisconnected)
+			}
+			if len(c.queue) != tt.wantQueued {
+				t.Errorf("queued %d messages, want %d", len(c.queue), tt.wantQueued)
+			}
+			if !tt.wantDisconnected && !reflect.DeepEqual(<-c.queue, tt.msg) {
+				t.Error("the new message is not queued")
+			}
+		})
+	}
+}
+
+func TestEnqueueRender(t *testing.T) {
+	full := contracts.RenderMessage{Type: contracts.MessageTypeRender, Rev: 3}
+	patch := &contracts.PatchMessage{Type: contracts.MessageTypePatch, Rev: 3, BaseRev: 2}
+
+	tests := []struct {
+		name      string
+		latestRev uint64
+		queued    int
+		want      any
+	}{
+		{"patch on the browser's revision", 2, 0, *patch},
+		{"browser at another revision", 1, 0, full},
+		{"queue full", 2, clientQueueSize, full},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newBrowserClient(testSession, &fakeConn{}, newMetricsRecorder())
+			c.latestRev.Store(tt.latestRev)
+			for range tt.queued {
+				c.queue <- contracts.CursorMessage{Type: contracts.MessageTypeCursor, Rev: tt.latestRev}
+			}
+
+			if !c.enqueueRender(full, patch) {
+				t.Fatal("enqueueRender() disconnected the browser")
+			}
+			var last any
+			for len(c.queue) > 0 {
+				last = <-c.queue
+			}
+			if !reflect.DeepEqual(last, tt.want) {
+				t.Errorf("queued %#v, want %#v", last, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnqueueClosed(t *testing.T) {
+	c := newBrowserClient(testSession, &fakeConn{}, newMetricsRecorder())
+	c.close()
+	if c.enqueue(contracts.RenderMessage{Type: contracts.MessageTypeRender, Rev: 1}) {
+		t.Error("enqueue() accepted a message for a closed client")
+	}
+}
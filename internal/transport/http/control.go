@@ -0,0 +1,81 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrDaemonRunning is returned by ListenControl when a daemon already
+// answers on the control socket.
+var ErrDaemonRunning = errors.New("preview daemon already running")
+
+// ListenControl listens on the daemon control socket. The socket's
+// directory is created private to the current user when missing, and must
+// be owned by the user and writable by nobody else, so that no other user
+// can put a socket of their own in its place. The socket itself is only
+// accessible to the user.
+func ListenControl(socket string) (net.Listener, error) {
+	if err := checkControlDir(socket, true); err != nil {
+		return nil, err
+	}
+
+	if conn, err := dialControl(socket, daemonDialTimeout); err == nil {
+		_ = conn.Close()
+		return nil, ErrDaemonRunning
+	}
+
+	// A socket file without a listener is left over from a crashed daemon.
+	_ = os.Remove(socket)
+
+	ctl, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socket, 0o600); err != nil {
+		_ = ctl.Close()
+		return nil, err
+	}
+	return ctl, nil
+}
+
+// dialControl connects to the daemon control socket after checking that
+// the socket and its directory belong to the current user.
+func dialControl(socket string, timeout time.Duration) (net.Conn, error) {
+	if err := checkControlDir(socket, false); err != nil {
+		return nil, err
+	}
+
+	info, err := os.Lstat(socket)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Type() != os.ModeSocket || !ownedByUser(info) {
+		return nil, fmt.Errorf("%s is not a control socket of the current user", socket)
+	}
+	return net.DialTimeout("unix", socket, timeout)
+}
+
+// checkControlDir checks that the directory of socket is owned by the
+// current user and not writable by anyone else, creating it with mode 0700
+// first when create is set.
+func checkControlDir(socket string, create bool) error {
+	dir := filepath.Dir(socket)
+	if create {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() || !ownedByUser(info) || !privateMode(info) {
+		return fmt.Errorf("control socket directory %s must be owned by the current user and not writable by others", dir)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package httpserver
+
+import (
+	"os"
+	"syscall"
+)
+
+// ownedByUser reports whether info describes a file of the current user.
+func ownedByUser(info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && int(stat.Uid) == os.Getuid()
+}
+
+// privateMode reports whether no other user can write to the file.
+func privateMode(info os.FileInfo) bool {
+	return info.Mode().Perm()&0o022 == 0
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package httpserver
+
+import "os"
+
+// ownedByUser always reports true; on Windows the control socket lives in
+// the user's profile, which is protected by its ACL.
+func ownedByUser(os.FileInfo) bool {
+	return true
+}
+
+// privateMode always reports true; see ownedByUser.
+func privateMode(os.FileInfo) bool {
+	return true
+}
@@ -0,0 +1,274 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-live-markdown/internal/contracts"
+)
+
+// daemonIdleTimeout bounds how long a daemon waits for its first client.
+const daemonIdleTimeout = 10 * time.Second
+
+// DaemonServer shares one PreviewServer between several editor hosts that
+// connect over a local control socket. Every client's sessions are served
+// under IDs prefixed with the client ID, so documents of all editors are
+// listed on the same index page.
+type DaemonServer struct {
+	preview *PreviewServer
+
+	mu      sync.Mutex
+	clients map[string]*daemonConn
+	nextID  int
+
+	// empty is signaled whenever the last client disconnects.
+	empty chan struct{}
+}
+
+// daemonConn is a single editor host connected to the daemon.
+type daemonConn struct {
+	id   string
+	conn net.Conn
+
+	writeMu sync.Mutex
+	enc     *json.Encoder
+
+	sessions map[string]struct{}
+}
+
+// NewDaemonServer wraps preview so it can be driven over control connections.
+func NewDaemonServer(preview *PreviewServer) *DaemonServer {
+	d := &DaemonServer{
+		preview: preview,
+		clients: make(map[string]*daemonConn),
+		empty:   make(chan struct{}, 1),
+	}
+
+	preview.SetGoToLineHandler(func(session string, msg contracts.GoToLineMessage) {
+		d.forward(session, contracts.ControlMessage{Type: contracts.ControlTypeGoToLine, GoToLine: &msg})
+	})
+	preview.SetToggleCheckboxHandler(func(session string, msg contracts.ToggleCheckboxMessage) {
+		d.forward(session, contracts.ControlMessage{Type: contracts.ControlTypeToggleCheckbox, ToggleCheckbox: &msg})
+	})
+	return d
+}
+
+// Serve starts the preview server and accepts control connections on ctl.
+// It returns once the last client has disconnected, or when no client
+// connects at all within daemonIdleTimeout.
+func (d *DaemonServer) Serve(ctl net.Listener) error {
+	if err := d.preview.Start(); err != nil {
+		return err
+	}
+	defer func() {
+		_ = d.preview.Stop()
+	}()
+
+	acceptErr := make(chan error, 1)
+	go func() {
+		for {
+			conn, err := ctl.Accept()
+			if err != nil {
+				acceptErr <- err
+				return
+			}
+			go d.serveConn(conn)
+		}
+	}()
+
+	idle := time.NewTimer(daemonIdleTimeout)
+	defer idle.Stop()
+
+	for {
+		select {
+		case <-d.empty:
+			_ = ctl.Close()
+			return nil
+		case <-idle.C:
+			if d.clientCount() == 0 {
+				_ = ctl.Close()
+				return nil
+			}
+		case err := <-acceptErr:
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func (d *DaemonServer) clientCount() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.clients)
+}
+
+// serveConn runs the control protocol for a single editor host.
+func (d *DaemonServer) serveConn(conn net.Conn) {
+	dec := json.NewDecoder(conn)
+
+	var hello contracts.ControlMessage
+	if err := dec.Decode(&hello); err != nil || hello.Type != contracts.ControlTypeHello {
+		_ = conn.Close()
+		return
+	}
+
+	c := d.addClient(conn, hello.Client)
+	slog.Info("editor connected to daemon", "client", c.id)
+	defer d.removeClient(c)
+
+	if !c.send(contracts.ControlMessage{
+		Type:   contracts.ControlTypeHello,
+		Client: c.id,
+		URL:    d.preview.URL(),
+	}) {
+		return
+	}
+
+	for {
+		var msg contracts.ControlMessage
+		if err := dec.Decode(&msg); err != nil {
+			return
+		}
+		if msg.Session == "" {
+			continue
+		}
+
+		session := c.id + "-" + msg.Session
+		switch msg.Type {
+		case contracts.ControlTypeRender:
+			d.mu.Lock()
+			c.sessions[session] = struct{}{}
+			d.mu.Unlock()
+			if msg.Token != "" {
+				d.preview.SetSessionToken(session, msg.Token)
+			}
+			if err := d.preview.StartOrUpdate(session, msg.Blocks, msg.TOC, msg.Path, msg.Assets); err != nil {
+				slog.Error("publishing session failed", "session", session, "err", err)
+			}
+		case contracts.ControlTypeCursor:
+			if msg.Cursor != nil {
+				if err := d.preview.UpdateCursor(session, *msg.Cursor); err != nil {
+					slog.Error("publishing cursor failed", "session", session, "err", err)
+				}
+			}
+		case contracts.ControlTypeStatus:
+			status := d.status(session)
+			if !c.send(contracts.ControlMessage{Type: contracts.ControlTypeStatus, Session: msg.Session, Status: &status}) {
+				return
+			}
+		case contracts.ControlTypeClose:
+			d.mu.Lock()
+			delete(c.sessions, session)
+			d.mu.Unlock()
+			d.preview.CloseSession(session)
+		}
+	}
+}
+
+// status counts the browsers of a session and reports its latest render.
+func (d *DaemonServer) status(session string) contracts.StatusMessage {
+	var status contracts.StatusMessage
+
+	info, ok := d.preview.SessionDebug(session)
+	if !ok {
+		return status
+	}
+	status.Rev = info.Rev
+	status.Browsers = len(info.Clients)
+	for _, client := range info.Clients {
+		if client.Viewer {
+			status.Viewers++
+		}
+	}
+	return status
+}
+
+// addClient registers a connected editor host. A host that reconnects asks
+// for its previous ID and keeps it unless another host took it, so the URLs
+// of its sessions stay the same.
+func (d *DaemonServer) addClient(conn net.Conn, previous string) *daemonConn {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	id := previous
+	n, err := strconv.Atoi(previous)
+	if err != nil || n < 1 || strconv.Itoa(n) != previous || d.clients[previous] != nil {
+		d.nextID++
+		id = strconv.Itoa(d.nextID)
+	} else {
+		d.nextID = max(d.nextID, n)
+	}
+	c := &daemonConn{
+		id:       id,
+		conn:     conn,
+		enc:      json.NewEncoder(conn),
+		sessions: make(map[string]struct{}),
+	}
+	d.clients[c.id] = c
+	return c
+}
+
+// removeClient drops a disconnected client together with its sessions.
+func (d *DaemonServer) removeClient(c *daemonConn) {
+	_ = c.conn.Close()
+	slog.Info("editor disconnected from daemon", "client", c.id)
+
+	d.mu.Lock()
+	delete(d.clients, c.id)
+	sessions := c.sessions
+	c.sessions = nil
+	remaining := len(d.clients)
+	d.mu.Unlock()
+
+	for session := range sessions {
+		d.preview.CloseSession(session)
+	}
+
+	if remaining == 0 {
+		select {
+		case d.empty <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// forward routes a browser event to the client owning the session.
+func (d *DaemonServer) forward(session string, msg contracts.ControlMessage) {
+	clientID, local, ok := strings.Cut(session, "-")
+	if !ok {
+		return
+	}
+
+	d.mu.Lock()
+	c, ok := d.clients[clientID]
+	d.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	msg.Session = local
+	c.send(msg)
+}
+
+// send writes a control message and reports whether the client is usable.
+func (c *daemonConn) send(msg contracts.ControlMessage) bool {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	_ = c.conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+	if err := c.enc.Encode(msg); err != nil {
+		slog.Warn("writing to editor failed", "client", c.id, "err", err)
+		_ = c.conn.Close()
+		return false
+	}
+	return true
+}
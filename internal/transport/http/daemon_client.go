@@ -0,0 +1,366 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"go-live-markdown/internal/contracts"
+)
+
+const (
+	daemonDialTimeout   = 500 * time.Millisecond
+	daemonSpawnTimeout  = 3 * time.Second
+	daemonStatusTimeout = 200 * time.Millisecond
+)
+
+// DaemonClient publishes preview sessions to a shared DaemonServer instead
+// of serving them in-process. It mirrors the PreviewServer API.
+type DaemonClient struct {
+	socket string
+	spawn  func() error
+
+	mu       sync.Mutex
+	conn     net.Conn
+	enc      *json.Encoder
+	client   string
+	indexURL string
+	baseURL  string
+
+	// tokens holds the access token of every session of this client.
+	tokens map[string]string
+	// statusWaiters holds the callers waiting for a status reply, per
+	// session in request order.
+	statusWaiters map[string][]chan contracts.StatusMessage
+
+	// OnGoToLine is invoked when the browser requests a jump to a source line.
+	OnGoToLine func(string, contracts.GoToLineMessage)
+	// OnToggleCheckbox is invoked when the browser requests a task toggle.
+	OnToggleCheckbox func(string, contracts.ToggleCheckboxMessage)
+}
+
+// NewDaemonClient creates a client for the daemon listening on socket.
+// spawn is called to launch the daemon when none is reachable.
+func NewDaemonClient(socket string, spawn func() error) *DaemonClient {
+	return &DaemonClient{
+		socket:        socket,
+		spawn:         spawn,
+		tokens:        make(map[string]string),
+		statusWaiters: make(map[string][]chan contracts.StatusMessage),
+	}
+}
+
+// URL returns the index URL of the shared preview server, once connected.
+func (d *DaemonClient) URL() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.indexURL
+}
+
+// SessionURL returns the browser URL for one of this client's sessions,
+// including its access token.
+func (d *DaemonClient) SessionURL(session string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.baseURL + sessionPath(d.client+"-"+session) + "?token=" + url.QueryEscape(d.tokenLocked(session))
+}
+
+// tokenLocked returns the access token of a session, creating it on first
+// use. The caller must hold d.mu.
+func (d *DaemonClient) tokenLocked(session string) string {
+	token, ok := d.tokens[session]
+	if !ok {
+		token = NewToken()
+		d.tokens[session] = token
+	}
+	return token
+}
+
+// Running reports whether the client is connected to a daemon.
+func (d *DaemonClient) Running() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.conn != nil
+}
+
+// StartOrUpdate connects to the daemon on first call and publishes new blocks
+// together with the local files they reference.
+func (d *DaemonClient) StartOrUpdate(session string, blocks []contracts.Block, toc []contracts.TOCItem, path string, assets map[string]string) error {
+	d.mu.Lock()
+	token := d.tokenLocked(session)
+	d.mu.Unlock()
+
+	return d.send(contracts.ControlMessage{
+		Type:    contracts.ControlTypeRender,
+		Session: session,
+		Blocks:  blocks,
+		TOC:     toc,
+		Path:    path,
+		Token:   token,
+		Assets:  assets,
+	}, true)
+}
+
+// UpdateCursor publishes a cursor update to browsers viewing the session.
+func (d *DaemonClient) UpdateCursor(session string, msg contracts.CursorMessage) error {
+	msg.Type = contracts.MessageTypeCursor
+	return d.send(contracts.ControlMessage{
+		Type:    contracts.ControlTypeCursor,
+		Session: session,
+		Cursor:  &msg,
+	}, false)
+}
+
+// CloseSession removes a session from the daemon.
+func (d *DaemonClient) CloseSession(session string) {
+	d.mu.Lock()
+	delete(d.tokens, session)
+	d.mu.Unlock()
+
+	_ = d.send(contracts.ControlMessage{
+		Type:    contracts.ControlTypeClose,
+		Session: session,
+	}, false)
+}
+
+// Status asks the daemon for the browsers and latest render revision of a
+// session. It reports false when the client is not connected or the daemon
+// does not answer within daemonStatusTimeout.
+func (d *DaemonClient) Status(session string) (contracts.StatusMessage, bool) {
+	reply := make(chan contracts.StatusMessage, 1)
+
+	d.mu.Lock()
+	d.statusWaiters[session] = append(d.statusWaiters[session], reply)
+	d.mu.Unlock()
+
+	err := d.send(contracts.ControlMessage{Type: contracts.ControlTypeStatus, Session: session}, false)
+	if err == nil && d.Running() {
+		select {
+		case status := <-reply:
+			return status, true
+		case <-time.After(daemonStatusTimeout):
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	waiters := d.statusWaiters[session]
+	for i, w := range waiters {
+		if w == reply {
+			d.statusWaiters[session] = append(waiters[:i:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(d.statusWaiters[session]) == 0 {
+		delete(d.statusWaiters, session)
+	}
+	select {
+	case status := <-reply:
+		return status, true
+	default:
+		return contracts.StatusMessage{}, false
+	}
+}
+
+// answerStatus hands a status reply to the oldest caller waiting for the
+// session.
+func (d *DaemonClient) answerStatus(session string, status contracts.StatusMessage) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	waiters := d.statusWaiters[session]
+	if len(waiters) == 0 {
+		return
+	}
+	waiters[0] <- status
+	if len(waiters) == 1 {
+		delete(d.statusWaiters, session)
+	} else {
+		d.statusWaiters[session] = waiters[1:]
+	}
+}
+
+// Stop disconnects from the daemon, which drops every session of this
+// client and exits once no editor is connected anymore.
+func (d *DaemonClient) Stop() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.conn == nil {
+		return nil
+	}
+
+	err := d.conn.Close()
+	d.conn = nil
+	d.enc = nil
+	return err
+}
+
+// errShareUnsupported is returned by the sharing methods; sessions served
+// by the shared daemon cannot be shared with viewers yet.
+var errShareUnsupported = errors.New("sharing is not supported with the shared preview daemon")
+
+// Share always fails; see errShareUnsupported.
+func (d *DaemonClient) Share(string) (string, error) {
+	return "", errShareUnsupported
+}
+
+// Unshare is a no-op; see errShareUnsupported.
+func (d *DaemonClient) Unshare(string) {}
+
+// Viewers always reports no viewers.
+func (d *DaemonClient) Viewers(string) []Viewer {
+	return nil
+}
+
+// SetGoToLineHandler registers the callback for browser go-to-line requests.
+func (d *DaemonClient) SetGoToLineHandler(fn func(string, contracts.GoToLineMessage)) {
+	d.OnGoToLine = fn
+}
+
+// SetToggleCheckboxHandler registers the callback for browser checkbox toggle requests.
+func (d *DaemonClient) SetToggleCheckboxHandler(fn func(string, contracts.ToggleCheckboxMessage)) {
+	d.OnToggleCheckbox = fn
+}
+
+// send writes a control message, connecting first when connect is set.
+func (d *DaemonClient) send(msg contracts.ControlMessage, connect bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.conn == nil {
+		if !connect {
+			return nil
+		}
+		if err := d.connect(); err != nil {
+			return err
+		}
+	}
+
+	_ = d.conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+	if err := d.enc.Encode(msg); err != nil {
+		slog.Warn("preview daemon connection lost", "socket", d.socket, "err", err)
+		_ = d.conn.Close()
+		d.conn = nil
+		d.enc = nil
+		return fmt.Errorf("preview daemon connection lost: %w", err)
+	}
+	return nil
+}
+
+// connect dials the daemon, spawning it when needed, and performs the hello
+// handshake. The caller must hold d.mu.
+func (d *DaemonClient) connect() error {
+	conn, err := dialControl(d.socket, daemonDialTimeout)
+	if err != nil && d.spawn != nil {
+		if err := d.spawn(); err != nil {
+			return fmt.Errorf("failed to start preview daemon: %w", err)
+		}
+		conn, err = d.dialRetry()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to preview daemon on %s: %w", d.socket, err)
+	}
+
+	enc := json.NewEncoder(conn)
+	dec := json.NewDecoder(conn)
+
+	// Asking for the previous client ID keeps session URLs stable when the
+	// connection drops and the daemon is still running.
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	var hello contracts.ControlMessage
+	err = enc.Encode(contracts.ControlMessage{Type: contracts.ControlTypeHello, Client: d.client})
+	if err == nil {
+		err = dec.Decode(&hello)
+	}
+	if err != nil || hello.Type != contracts.ControlTypeHello || hello.Client == "" {
+		_ = conn.Close()
+		return fmt.Errorf("preview daemon on %s did not answer the handshake", d.socket)
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	d.conn = conn
+	d.enc = enc
+	d.client = hello.Client
+	d.indexURL = hello.URL
+	d.baseURL = hello.URL
+	if u, err := url.Parse(hello.URL); err == nil {
+		d.baseURL = u.Scheme + "://" + u.Host
+	}
+
+	go d.readLoop(conn, dec)
+	return nil
+}
+
+// dialRetry waits for a freshly spawned daemon to accept connections.
+func (d *DaemonClient) dialRetry() (net.Conn, error) {
+	deadline := time.Now().Add(daemonSpawnTimeout)
+	for {
+		conn, err := dialControl(d.socket, daemonDialTimeout)
+		if err == nil || time.Now().After(deadline) {
+			return conn, err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// readLoop dispatches browser events forwarded by the daemon. They run on
+// their own goroutine, one at a time, so a slow editor never holds up
+// status replies; like the in-process server, it drops events while the
+// editor is backed up.
+func (d *DaemonClient) readLoop(conn net.Conn, dec *json.Decoder) {
+	actions := make(chan func(), 16)
+	go func() {
+		for fn := range actions {
+			fn()
+		}
+	}()
+	dispatch := func(fn func()) {
+		select {
+		case actions <- fn:
+		default:
+		}
+	}
+
+	defer func() {
+		close(actions)
+		d.mu.Lock()
+		if d.conn == conn {
+			_ = conn.Close()
+			d.conn = nil
+			d.enc = nil
+		}
+		d.mu.Unlock()
+	}()
+
+	for {
+		var msg contracts.ControlMessage
+		if err := dec.Decode(&msg); err != nil {
+			return
+		}
+
+		switch msg.Type {
+		case contracts.ControlTypeGoToLine:
+			if fn := d.OnGoToLine; msg.GoToLine != nil && fn != nil {
+				dispatch(func() { fn(msg.Session, *msg.GoToLine) })
+			}
+		case contracts.ControlTypeToggleCheckbox:
+			if fn := d.OnToggleCheckbox; msg.ToggleCheckbox != nil && fn != nil {
+				dispatch(func() { fn(msg.Session, *msg.ToggleCheckbox) })
+			}
+		case contracts.ControlTypeStatus:
+			if msg.Status != nil {
+				d.answerStatus(msg.Session, *msg.Status)
+			}
+		}
+	}
+}
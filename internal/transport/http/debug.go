@@ -0,0 +1,156 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"go-live-markdown/internal/logging"
+)
+
+// DebugState is a snapshot of the server for troubleshooting, as served on
+// /debug/state.
+type DebugState struct {
+	Running   bool   `json:"running"`
+	Addr      string `json:"addr"`
+	ShareAddr string `json:"share_addr,omitempty"`
+	// Daemon is the index URL of the shared daemon when it serves the
+	// sessions instead; its own /debug/state lists them.
+	Daemon       string          `json:"daemon,omitempty"`
+	Sessions     []SessionDebug  `json:"sessions"`
+	RecentErrors []logging.Entry `json:"recent_errors"`
+	Metrics      Metrics         `json:"metrics"`
+}
+
+// SessionDebug describes a preview session and its browsers.
+type SessionDebug struct {
+	ID       string        `json:"id"`
+	Filename string        `json:"filename"`
+	Rev      uint64        `json:"rev"`
+	Blocks   int           `json:"blocks"`
+	Shared   bool          `json:"shared"`
+	Clients  []ClientDebug `json:"clients"`
+}
+
+// ClientDebug describes a browser connected to a session.
+type ClientDebug struct {
+	Transport string    `json:"transport"`
+	Viewer    bool      `json:"viewer"`
+	Presenter bool      `json:"presenter"`
+	Addr      string    `json:"addr"`
+	Browser   string    `json:"browser"`
+	Since     time.Time `json:"since"`
+	// Rev is the newest revision queued for the browser; Queued counts
+	// the messages it has not been sent yet.
+	Rev    uint64 `json:"rev"`
+	Queued int    `json:"queued"`
+}
+
+// DebugState returns a snapshot of the sessions, their browsers and the
+// problems logged recently.
+func (m *PreviewServer) DebugState() DebugState {
+	m.mu.Lock()
+	state := DebugState{
+		Running:   m.started,
+		Addr:      m.addr,
+		ShareAddr: m.shareAddr,
+	}
+	stop := m.stopLoop
+	m.mu.Unlock()
+
+	if state.Running {
+		reply := make(chan []SessionDebug, 1)
+		select {
+		case m.inspect <- reply:
+			state.Sessions = <-reply
+		case <-stop:
+		}
+	}
+	if state.Sessions == nil {
+		state.Sessions = []SessionDebug{}
+	}
+
+	m.sessionsMu.Lock()
+	for i := range state.Sessions {
+		if info, ok := m.sessions[state.Sessions[i].ID]; ok {
+			state.Sessions[i].Filename = info.filename
+			state.Sessions[i].Shared = info.viewerToken != ""
+		}
+	}
+	m.sessionsMu.Unlock()
+
+	state.RecentErrors = logging.Recent()
+	state.Metrics = m.Metrics()
+	return state
+}
+
+// SessionDebug describes a single session as in DebugState, without the
+// rest of the snapshot.
+func (m *PreviewServer) SessionDebug(session string) (SessionDebug, bool) {
+	stop, ok := m.running()
+	if !ok {
+		return SessionDebug{}, false
+	}
+
+	reply := make(chan []SessionDebug, 1)
+	select {
+	case m.inspect <- reply:
+	case <-stop:
+		return SessionDebug{}, false
+	}
+	for _, s := range <-reply {
+		if s.ID == session {
+			return s, true
+		}
+	}
+	return SessionDebug{}, false
+}
+
+// debugSessions describes the sessions owned by runLoop. It must only be
+// called from runLoop.
+func debugSessions(sessions map[string]*sessionState) []SessionDebug {
+	out := make([]SessionDebug, 0, len(sessions))
+	for id, s := range sessions {
+		session := SessionDebug{
+			ID:      id,
+			Rev:     s.lastRender.Rev,
+			Blocks:  len(s.blocks),
+			Clients: make([]ClientDebug, 0, len(s.clients)),
+		}
+		presenter := s.presenterClient()
+		for c := range s.clients {
+			session.Clients = append(session.Clients, ClientDebug{
+				Transport: c.transport(),
+				Viewer:    c.viewer,
+				Presenter: c == presenter,
+				Addr:      c.addr,
+				Browser:   c.browser,
+				Since:     c.since,
+				Rev:       c.latestRev.Load(),
+				Queued:    len(c.queue),
+			})
+		}
+		sort.Slice(session.Clients, func(i, j int) bool {
+			return session.Clients[i].Since.Before(session.Clients[j].Since)
+		})
+		out = append(out, session)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// handleDebugState serves DebugState as JSON. Like the index, it requires
+// the server token.
+func (m *PreviewServer) handleDebugState(w http.ResponseWriter, r *http.Request) {
+	if !m.indexAuthorized(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(m.DebugState())
+}
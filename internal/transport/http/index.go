@@ -0,0 +1,51 @@
+package httpserver
+
+import "html/template"
+
+// indexEntry is a single document listed on the preview index page.
+type indexEntry struct {
+	ID       string
+	Filename string
+	URL      string
+}
+
+// indexTemplate renders the list of active preview sessions.
+var indexTemplate = template.Must(template.New("index").Parse(`<!doctype html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>Go Live Markdown</title>
+  <style>
+    :root { color-scheme: dark light; }
+    body {
+      margin: 0 auto;
+      max-width: 720px;
+      padding: 48px 24px;
+      font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif;
+      background: #0d1117;
+      color: #e6edf3;
+    }
+    h1 { font-size: 1.4rem; font-weight: 600; }
+    ul { list-style: none; padding: 0; }
+    li { padding: 10px 0; border-bottom: 1px solid #30363d; }
+    a { color: #4493f8; text-decoration: none; }
+    a:hover { text-decoration: underline; }
+    .empty, .session-id { color: #8b949e; }
+    .session-id { font-size: 0.85rem; margin-left: 8px; }
+  </style>
+</head>
+<body>
+  <h1>Go Live Markdown</h1>
+  {{- if . }}
+  <ul>
+    {{- range . }}
+    <li><a href="{{ .URL }}">{{ .Filename }}</a><span class="session-id">#{{ .ID }}</span></li>
+    {{- end }}
+  </ul>
+  {{- else }}
+  <p class="empty">No documents are being previewed. Run :GoLiveMarkdownStart in a markdown buffer.</p>
+  {{- end }}
+</body>
+</html>
+`))
@@ -0,0 +1,63 @@
+package httpserver
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// ListenConfig selects the address the preview server binds to.
+type ListenConfig struct {
+	// Host is the interface to bind, e.g. 127.0.0.1.
+	Host string
+	// Port is the preferred port.
+	Port int
+	// PortRange is the inclusive range probed when Port is busy.
+	// A zero range disables probing.
+	PortRange [2]int
+}
+
+// Address returns the preferred host:port of the configuration.
+func (c ListenConfig) Address() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+}
+
+// listen binds the preferred port and falls back to the first free port in
+// the configured range, then to any free port chosen by the OS.
+func listen(cfg ListenConfig) (net.Listener, error) {
+	listener, err := net.Listen("tcp", cfg.Address())
+	if err == nil {
+		return listener, nil
+	}
+
+	first, last := cfg.PortRange[0], cfg.PortRange[1]
+	for port := first; first > 0 && port <= last; port++ {
+		if port == cfg.Port {
+			continue
+		}
+
+		listener, err := net.Listen("tcp", net.JoinHostPort(cfg.Host, strconv.Itoa(port)))
+		if err == nil {
+			return listener, nil
+		}
+	}
+
+	listener, err = net.Listen("tcp", net.JoinHostPort(cfg.Host, "0"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to start preview server on %s: %w", cfg.Address(), err)
+	}
+	return listener, nil
+}
+
+// CheckListen binds cfg the way the preview server would and releases the
+// port right away. It returns the address that was bound, which differs
+// from cfg.Address when the preferred port is busy.
+func CheckListen(cfg ListenConfig) (string, error) {
+	listener, err := listen(cfg)
+	if err != nil {
+		return "", err
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+	return addr, nil
+}
@@ -0,0 +1,137 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"go-live-markdown/internal/contracts"
+)
+
+// compressThreshold is the size from which messages to a browser are
+// compressed, when the browser negotiated permessage-deflate. Smaller
+// messages such as cursor updates are not worth the CPU time.
+const compressThreshold = 2 << 10
+
+// Metrics is a snapshot of render durations and of the sizes of messages
+// written to browsers, as served on /debug/metrics.
+type Metrics struct {
+	Renders RenderMetrics `json:"renders"`
+	// Messages is keyed by message type.
+	Messages map[string]MessageMetrics `json:"messages"`
+}
+
+// RenderMetrics summarizes how long documents took to render.
+type RenderMetrics struct {
+	Count  int     `json:"count"`
+	LastMs float64 `json:"last_ms"`
+	AvgMs  float64 `json:"avg_ms"`
+	MaxMs  float64 `json:"max_ms"`
+}
+
+// MessageMetrics summarizes the messages of one type written to browsers.
+// Sizes are those of the JSON payload before compression.
+type MessageMetrics struct {
+	Count      int   `json:"count"`
+	Compressed int   `json:"compressed"`
+	Bytes      int64 `json:"bytes"`
+	LastBytes  int   `json:"last_bytes"`
+	MaxBytes   int   `json:"max_bytes"`
+}
+
+// metricsRecorder collects Metrics. Messages are recorded by the write
+// loops of all clients, renders by whoever renders the documents.
+type metricsRecorder struct {
+	mu          sync.Mutex
+	renders     RenderMetrics
+	renderTotal time.Duration
+	messages    map[string]MessageMetrics
+}
+
+func newMetricsRecorder() *metricsRecorder {
+	return &metricsRecorder{messages: make(map[string]MessageMetrics)}
+}
+
+func (r *metricsRecorder) recordRender(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ms := float64(d.Microseconds()) / 1000
+	r.renderTotal += d
+	r.renders.Count++
+	r.renders.LastMs = ms
+	r.renders.AvgMs = float64(r.renderTotal.Microseconds()) / 1000 / float64(r.renders.Count)
+	r.renders.MaxMs = max(r.renders.MaxMs, ms)
+}
+
+func (r *metricsRecorder) recordMessage(msg any, size int, compressed bool) {
+	kind := messageType(msg)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m := r.messages[kind]
+	m.Count++
+	if compressed {
+		m.Compressed++
+	}
+	m.Bytes += int64(size)
+	m.LastBytes = size
+	m.MaxBytes = max(m.MaxBytes, size)
+	r.messages[kind] = m
+}
+
+func (r *metricsRecorder) snapshot() Metrics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	messages := make(map[string]MessageMetrics, len(r.messages))
+	for kind, m := range r.messages {
+		messages[kind] = m
+	}
+	return Metrics{Renders: r.renders, Messages: messages}
+}
+
+// messageType returns the type field of a message queued for a browser.
+func messageType(msg any) string {
+	switch m := msg.(type) {
+	case contracts.RenderMessage:
+		return m.Type
+	case contracts.PatchMessage:
+		return m.Type
+	case contracts.CursorMessage:
+		return m.Type
+	case contracts.ScrollMessage:
+		return m.Type
+	case contracts.FoldMessage:
+		return m.Type
+	case contracts.ViewportMessage:
+		return m.Type
+	}
+	return "other"
+}
+
+// RecordRender records how long a document took to render.
+func (m *PreviewServer) RecordRender(d time.Duration) {
+	m.metrics.recordRender(d)
+}
+
+// Metrics returns the render durations and message sizes recorded since
+// the server was created.
+func (m *PreviewServer) Metrics() Metrics {
+	return m.metrics.snapshot()
+}
+
+// handleMetrics serves Metrics as JSON. Like the index, it requires the
+// server token.
+func (m *PreviewServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if !m.indexAuthorized(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(m.Metrics())
+}
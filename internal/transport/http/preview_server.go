@@ -3,314 +3,860 @@ package httpserver
 
 import (
 	"context"
-	"encoding/base64"
 	"encoding/json"
-	"fmt"
-	"net"
+	"io/fs"
+	"log/slog"
 	"net/http"
-	"os"
+	"net/url"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"go-live-markdown/internal/contracts"
+	"go-live-markdown/internal/render"
 
 	"github.com/gorilla/websocket"
 )
 
 type renderPayload struct {
-	html     string
+	session  string
+	blocks   []contracts.Block
 	toc      []contracts.TOCItem
 	filename string
 }
 
+type cursorPayload struct {
+	session string
+	msg     contracts.CursorMessage
+}
+
+type inboundPayload struct {
+	session string
+	raw     []byte
+	client  *browserClient
+}
+
+// sessionState is the per-document state owned by runLoop.
+type sessionState struct {
+	clients    map[*browserClient]struct{}
+	lastRender contracts.RenderMessage
+	lastCursor contracts.CursorMessage
+	haveCursor bool
+
+	// blocks are the blocks of lastRender; blockSeq numbers their IDs.
+	blocks   []sessionBlock
+	blockSeq uint64
+
+	// The presenter's view, replayed to browsers that join later.
+	presenter  *browserClient
+	lastScroll *contracts.ScrollMessage
+	lastFold   *contracts.FoldMessage
+}
+
+// presenterClient returns the browser whose scroll position and folds the
+// other browsers follow: the longest connected one that is not a read-only
+// viewer. When the presenter disconnects, the next one takes over.
+func (s *sessionState) presenterClient() *browserClient {
+	if _, ok := s.clients[s.presenter]; ok {
+		return s.presenter
+	}
+
+	s.presenter = nil
+	for c := range s.clients {
+		if !c.viewer && (s.presenter == nil || c.since.Before(s.presenter.since)) {
+			s.presenter = c
+		}
+	}
+	return s.presenter
+}
+
 // PreviewServer coordinates HTTP serving and WebSocket updates.
 type PreviewServer struct {
-	addr  string
 	shell string
 
-	started bool
-	server  *http.Server
+	// mu guards the server lifecycle fields below.
+	mu       sync.Mutex
+	listen   ListenConfig
+	addr     string
+	token    string
+	started  bool
+	server   *http.Server
+	stopLoop chan struct{}
+	loopDone chan struct{}
+
+	// The share listener serves shared sessions to viewers on another
+	// interface; see share.go.
+	shareConfig ShareConfig
+	shareServer *http.Server
+	shareAddr   string
+
+	// sessions maps session IDs to their filename and access token for the
+	// HTTP handlers.
+	sessionsMu sync.Mutex
+	sessions   map[string]*sessionInfo
+	// streams maps the IDs of Server-Sent Events streams to their clients,
+	// so messages posted by those browsers reach runLoop; see sse.go.
+	streams map[string]*browserClient
 
 	// OnGoToLine is invoked when the browser requests a jump to a source line.
-	OnGoToLine func(contracts.GoToLineMessage)
+	OnGoToLine func(string, contracts.GoToLineMessage)
 	// OnToggleCheckbox is invoked when the browser requests a task toggle.
-	OnToggleCheckbox func(contracts.ToggleCheckboxMessage)
-	browserInbound   chan []byte
+	OnToggleCheckbox func(string, contracts.ToggleCheckboxMessage)
+	browserInbound   chan inboundPayload
+
+	// actions carries browser-to-editor callbacks from runLoop to the
+	// dispatcher, so a busy editor never stalls browser traffic.
+	actions chan func()
 
 	updates    chan renderPayload
-	cursors    chan contracts.CursorMessage
-	register   chan *websocket.Conn
-	unregister chan *websocket.Conn
-	stopLoop   chan struct{}
+	cursors    chan cursorPayload
+	closed     chan string
+	register   chan *browserClient
+	unregister chan *browserClient
+	// inspect asks runLoop to describe its sessions; see debug.go.
+	inspect chan chan<- []SessionDebug
 
 	upgrader websocket.Upgrader
+	metrics  *metricsRecorder
 }
 
-// NewPreviewServer creates an HTTP/WebSocket preview server bound to addr.
-func NewPreviewServer(addr string, shell string) *PreviewServer {
+// NewPreviewServer creates an HTTP/WebSocket preview server that binds
+// according to cfg once the first session is published.
+func NewPreviewServer(cfg ListenConfig, shell string) *PreviewServer {
 	return &PreviewServer{
-		addr:  addr,
-		shell: shell,
+		listen: cfg,
+		addr:   cfg.Address(),
+		shell:  shell,
 
-		browserInbound: make(chan []byte, 64),
+		sessions: make(map[string]*sessionInfo),
+		streams:  make(map[string]*browserClient),
+
+		browserInbound: make(chan inboundPayload, 64),
+		actions:        make(chan func(), 16),
 		updates:        make(chan renderPayload, 8),
-		cursors:        make(chan contracts.CursorMessage, 32),
-		register:       make(chan *websocket.Conn),
-		unregister:     make(chan *websocket.Conn),
-		stopLoop:       make(chan struct{}),
+		cursors:        make(chan cursorPayload, 32),
+		closed:         make(chan string, 8),
+		register:       make(chan *browserClient),
+		unregister:     make(chan *browserClient),
+		inspect:        make(chan chan<- []SessionDebug),
 		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool { return true },
+			CheckOrigin:       sameOrigin,
+			EnableCompression: true,
 		},
+		metrics: newMetricsRecorder(),
 	}
 }
 
-// URL returns the browser URL for the preview server.
+// URL returns the browser URL of the index page, including the token that
+// grants access to it. Once started it reflects the address that was
+// actually bound.
 func (m *PreviewServer) URL() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token == "" {
+		return "http://" + m.addr + "/"
+	}
+	return "http://" + m.addr + "/?token=" + url.QueryEscape(m.token)
+}
+
+// baseURL returns the scheme and address the server is reachable at.
+func (m *PreviewServer) baseURL() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	return "http://" + m.addr
 }
 
-// StartOrUpdate starts the preview server on first call and publishes new HTML.
-func (m *PreviewServer) StartOrUpdate(fragment string, toc []contracts.TOCItem, path string) error {
+// SetListenConfig changes where the server binds. It takes effect the next
+// time the server starts.
+func (m *PreviewServer) SetListenConfig(cfg ListenConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.listen = cfg
 	if !m.started {
-		mux := http.NewServeMux()
-		mux.HandleFunc("/", m.handleIndex)
-		mux.HandleFunc("/ws", m.handleWS)
-		mux.HandleFunc("/@mdfs/", m.handleAsset)
+		m.addr = cfg.Address()
+	}
+}
 
-		m.server = &http.Server{Addr: m.addr, Handler: mux}
+// SessionURL returns the browser URL for a single preview session,
+// including its access token.
+func (m *PreviewServer) SessionURL(session string) string {
+	m.sessionsMu.Lock()
+	info, ok := m.sessions[session]
+	m.sessionsMu.Unlock()
 
-		listener, err := net.Listen("tcp", m.addr)
-		if err != nil {
-			return fmt.Errorf("failed to start preview server on %s: %w", m.addr, err)
-		}
-		m.started = true
+	u := m.baseURL() + sessionPath(session)
+	if ok {
+		u += "?token=" + url.QueryEscape(info.token)
+	}
+	return u
+}
 
-		go m.runLoop()
-		go func() {
-			if err := m.server.Serve(listener); err != nil && err != http.ErrServerClosed {
-				_ = listener.Close()
-			}
-		}()
+// SetSessionToken sets the access token of a session, replacing the token
+// generated when it was first published.
+func (m *PreviewServer) SetSessionToken(session string, token string) {
+	m.sessionsMu.Lock()
+	defer m.sessionsMu.Unlock()
+
+	if info, ok := m.sessions[session]; ok {
+		info.token = token
+		return
+	}
+	m.sessions[session] = &sessionInfo{token: token}
+}
+
+func sessionPath(session string) string {
+	return "/doc/" + url.PathEscape(session)
+}
+
+// StartOrUpdate starts the preview server on first call and publishes the
+// rendered blocks to the given session, creating the session if needed.
+// assets maps the asset handles used in blocks to the local files served
+// for them. A render that races with Stop is dropped.
+func (m *PreviewServer) StartOrUpdate(session string, blocks []contracts.Block, toc []contracts.TOCItem, path string, assets map[string]string) error {
+	stop, err := m.startLoop()
+	if err != nil {
+		return err
 	}
 
 	filename := filepath.Base(path)
-	m.updates <- renderPayload{html: fragment, toc: toc, filename: filename}
+	m.sessionsMu.Lock()
+	// Stop closes the loop before it forgets the sessions, so a closed
+	// loop means the session would outlive the server.
+	select {
+	case <-stop:
+		m.sessionsMu.Unlock()
+		return nil
+	default:
+	}
+	info, ok := m.sessions[session]
+	if !ok {
+		info = &sessionInfo{token: NewToken()}
+		m.sessions[session] = info
+	}
+	info.filename = filename
+	info.assets = assets
+	m.sessionsMu.Unlock()
+
+	select {
+	case m.updates <- renderPayload{session: session, blocks: blocks, toc: toc, filename: filename}:
+	case <-stop:
+	}
 	return nil
 }
 
-// UpdateCursor publishes a cursor update to connected browsers.
-func (m *PreviewServer) UpdateCursor(msg contracts.CursorMessage) error {
+// Start binds the listener and launches the server unless it already runs.
+func (m *PreviewServer) Start() error {
+	_, err := m.startLoop()
+	return err
+}
+
+// startLoop starts the server unless it already runs and returns the stop
+// channel of its run loop, read under the same lock, so a concurrent Stop
+// cannot leave the caller without one.
+func (m *PreviewServer) startLoop() (chan struct{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	if !m.started {
+		if err := m.start(); err != nil {
+			return nil, err
+		}
+	}
+	return m.stopLoop, nil
+}
+
+// Running reports whether the server is currently serving.
+func (m *PreviewServer) Running() bool {
+	_, ok := m.running()
+	return ok
+}
+
+// start binds the listener and launches the HTTP server and run loop.
+// The caller must hold m.mu.
+func (m *PreviewServer) start() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", m.handleIndex)
+	mux.HandleFunc("/doc/", m.handleDocument)
+	mux.HandleFunc("/ws", m.handleWS)
+	mux.HandleFunc("/events", m.handleEvents)
+	mux.HandleFunc("/send", m.handleSend)
+	mux.HandleFunc("/debug/metrics", m.handleMetrics)
+	mux.HandleFunc("/debug/state", m.handleDebugState)
+	mux.HandleFunc(render.AssetPrefix, m.handleAsset)
+	mux.HandleFunc(render.VendorPrefix, m.handleVendor)
+
+	listener, err := listen(m.listen)
+	if err != nil {
+		return err
+	}
+
+	m.addr = listener.Addr().String()
+	m.token = NewToken()
+	server := &http.Server{Addr: m.addr, Handler: mux}
+
+	m.server = server
+	m.started = true
+	m.stopLoop = make(chan struct{})
+	m.loopDone = make(chan struct{})
+
+	go m.runLoop(m.stopLoop, m.loopDone)
+	go m.dispatchLoop(m.stopLoop)
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("preview server stopped serving", "addr", m.addr, "err", err)
+			_ = listener.Close()
+		}
+	}()
+	slog.Info("preview server started", "addr", m.addr)
+	return nil
+}
+
+// running returns the stop channel of the current run loop, if any.
+func (m *PreviewServer) running() (chan struct{}, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.stopLoop, m.started
+}
+
+// UpdateCursor publishes a cursor update to browsers viewing the session.
+func (m *PreviewServer) UpdateCursor(session string, msg contracts.CursorMessage) error {
+	stop, ok := m.running()
+	if !ok {
 		return nil
 	}
 
 	msg.Type = contracts.MessageTypeCursor
-	m.cursors <- msg
+	select {
+	case m.cursors <- cursorPayload{session: session, msg: msg}:
+	case <-stop:
+	}
 	return nil
 }
 
-// Stop gracefully shuts down the HTTP server and run loop.
+// CloseSession forgets a session and disconnects its browser.
+func (m *PreviewServer) CloseSession(session string) {
+	m.sessionsMu.Lock()
+	_, known := m.sessions[session]
+	delete(m.sessions, session)
+	m.sessionsMu.Unlock()
+
+	stop, ok := m.running()
+	if !known || !ok {
+		return
+	}
+
+	select {
+	case m.closed <- session:
+	case <-stop:
+	}
+}
+
+// hasSession reports whether the session has been published at least once.
+func (m *PreviewServer) hasSession(session string) bool {
+	m.sessionsMu.Lock()
+	defer m.sessionsMu.Unlock()
+
+	_, ok := m.sessions[session]
+	return ok
+}
+
+// sessionList returns a snapshot of the known sessions sorted by ID.
+func (m *PreviewServer) sessionList() []indexEntry {
+	m.sessionsMu.Lock()
+	defer m.sessionsMu.Unlock()
+
+	out := make([]indexEntry, 0, len(m.sessions))
+	for id, info := range m.sessions {
+		out = append(out, indexEntry{
+			ID:       id,
+			Filename: info.filename,
+			URL:      sessionPath(id) + "?token=" + url.QueryEscape(info.token),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Stop gracefully shuts down the HTTP server and run loop, closes every
+// browser connection and frees the listen address. The server can be
+// started again by the next StartOrUpdate call.
 func (m *PreviewServer) Stop() error {
+	m.mu.Lock()
 	if !m.started || m.server == nil {
+		m.mu.Unlock()
 		return nil
 	}
 
+	server, shareServer, stop, done := m.server, m.shareServer, m.stopLoop, m.loopDone
+	m.started = false
+	m.server = nil
+	m.shareServer = nil
+	m.shareAddr = ""
+	m.addr = m.listen.Address()
+	m.token = ""
+	m.mu.Unlock()
+
+	// Closing the loop first disconnects websockets, which Shutdown ignores.
+	close(stop)
+	<-done
+
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 
-	err := m.server.Shutdown(ctx)
-
-	close(m.stopLoop)
+	err := server.Shutdown(ctx)
+	if shareServer != nil {
+		_ = shareServer.Shutdown(ctx)
+	}
 
-	m.started = false
-	m.server = nil
+	m.sessionsMu.Lock()
+	m.sessions = make(map[string]*sessionInfo)
+	m.sessionsMu.Unlock()
 	return err
 }
 
-// handleIndex serves the initial HTML shell.
+// handleIndex serves the list of documents currently being previewed.
 func (m *PreviewServer) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if !m.indexAuthorized(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = indexTemplate.Execute(w, m.sessionList())
+}
+
+// handleDocument serves the HTML shell for a single preview session.
+func (m *PreviewServer) handleDocument(w http.ResponseWriter, r *http.Request) {
+	session := strings.Trim(strings.TrimPrefix(r.URL.Path, "/doc/"), "/")
+	if session == "" || !m.hasSession(session) {
+		http.NotFound(w, r)
+		return
+	}
+	if m.sessionAccess(r, session) == accessNone {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	if token := r.URL.Query().Get("token"); token != "" {
+		setSessionCookie(w, session, token)
+	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	_, _ = w.Write([]byte(m.shell))
 }
 
 // handleWS upgrades the connection and forwards browser messages to the loop.
 func (m *PreviewServer) handleWS(w http.ResponseWriter, r *http.Request) {
+	session := r.URL.Query().Get("doc")
+	if session == "" || !m.hasSession(session) {
+		http.NotFound(w, r)
+		return
+	}
+	role := m.sessionAccess(r, session)
+	if role == accessNone {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
 	conn, err := m.upgrader.Upgrade(w, r, nil)
 	if err != nil {
+		slog.Debug("websocket upgrade failed", "session", session, "remote", r.RemoteAddr, "err", err)
 		return
 	}
 
-	m.register <- conn
-	defer func() {
-		m.unregister <- conn
-	}()
+	stop, ok := m.running()
+	if !ok {
+		_ = conn.Close()
+		return
+	}
 
-	// Block here until the connection closes / errors outs
+	ws := newWSConn(conn, offersDeflate(r))
+	client, disconnect, ok := m.connectClient(stop, r, session, role, ws)
+	if !ok {
+		return
+	}
+	defer disconnect()
+	go client.writeLoop()
+
+	// Block here until the connection closes, errors out or goes silent.
 	for {
-		_, msg, err := conn.ReadMessage()
+		msg, err := ws.read()
 		if err != nil {
 			return
 		}
-		m.browserInbound <- msg
+		if !m.receive(stop, client, msg) {
+			return
+		}
+	}
+}
+
+// offersDeflate reports whether the browser offered permessage-deflate,
+// which the upgrader then accepts.
+func offersDeflate(r *http.Request) bool {
+	for _, header := range r.Header.Values("Sec-WebSocket-Extensions") {
+		for _, ext := range strings.Split(header, ",") {
+			name, _, _ := strings.Cut(ext, ";")
+			if strings.TrimSpace(name) == "permessage-deflate" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// connectClient registers a browser connection with runLoop, which replays
+// the latest state into its queue. The caller starts the client's write
+// loop and calls disconnect once the connection ends.
+func (m *PreviewServer) connectClient(stop <-chan struct{}, r *http.Request, session string, role access, conn clientConn) (client *browserClient, disconnect func(), ok bool) {
+	client = newBrowserClient(session, conn, m.metrics)
+	client.addr = r.RemoteAddr
+	client.browser = browserName(r.UserAgent())
+	if role == accessViewer {
+		client.viewer = true
+		m.addViewer(client)
+	}
+	slog.Debug("browser connected", "session", session, "transport", client.transport(),
+		"viewer", client.viewer, "remote", client.addr)
+
+	select {
+	case m.register <- client:
+	case <-stop:
+		client.close()
+		m.removeViewer(client)
+		return nil, nil, false
+	}
+
+	disconnect = func() {
+		select {
+		case m.unregister <- client:
+		case <-stop:
+		}
+		m.removeViewer(client)
+	}
+	return client, disconnect, true
+}
+
+// receive hands a browser message to runLoop.
+func (m *PreviewServer) receive(stop <-chan struct{}, client *browserClient, raw []byte) bool {
+	select {
+	case m.browserInbound <- inboundPayload{session: client.session, raw: raw, client: client}:
+		return true
+	case <-stop:
+		return false
 	}
 }
 
 // SetGoToLineHandler registers the callback for browser go-to-line requests.
-func (m *PreviewServer) SetGoToLineHandler(fn func(contracts.GoToLineMessage)) {
+func (m *PreviewServer) SetGoToLineHandler(fn func(string, contracts.GoToLineMessage)) {
 	m.OnGoToLine = fn
 }
 
 // SetToggleCheckboxHandler registers the callback for browser checkbox toggle requests.
-func (m *PreviewServer) SetToggleCheckboxHandler(fn func(contracts.ToggleCheckboxMessage)) {
+func (m *PreviewServer) SetToggleCheckboxHandler(fn func(string, contracts.ToggleCheckboxMessage)) {
 	m.OnToggleCheckbox = fn
 }
 
-// handleAsset serves local markdown assets via encoded absolute paths.
+// handleAsset serves a local file referenced by a document. Files are
+// addressed by the opaque handles issued while rendering, which only exist
+// for files inside the allowed roots.
 func (m *PreviewServer) handleAsset(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	id := strings.TrimPrefix(r.URL.Path, "/@mdfs/")
-	if id == "" {
+	handle := strings.TrimPrefix(r.URL.Path, render.AssetPrefix)
+	if handle == "" {
 		http.NotFound(w, r)
 		return
 	}
 
-	decoded, err := base64.RawURLEncoding.DecodeString(id)
-	if err != nil {
+	assetPath, ok := m.lookupAsset(r, handle)
+	if !ok || !render.ValidAsset(assetPath) {
 		http.NotFound(w, r)
 		return
 	}
 
-	assetPath := filepath.Clean(string(decoded))
-	if assetPath == "." || !filepath.IsAbs(assetPath) {
-		http.NotFound(w, r)
+	http.ServeFile(w, r, assetPath)
+}
+
+// handleVendor serves the browser runtimes embedded in the host. They are
+// public and pinned, so they need no token and may be cached.
+func (m *PreviewServer) handleVendor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	info, err := os.Stat(assetPath)
-	if err != nil || info.IsDir() {
+	name := strings.TrimPrefix(r.URL.Path, render.VendorPrefix)
+	if !strings.HasSuffix(name, ".js") || !fs.ValidPath(name) {
+		http.NotFound(w, r)
+		return
+	}
+	if _, err := fs.Stat(render.Vendor, name); err != nil {
 		http.NotFound(w, r)
 		return
 	}
 
-	http.ServeFile(w, r, assetPath)
+	w.Header().Set("Cache-Control", "max-age=86400")
+	http.ServeFileFS(w, r, render.Vendor, name)
 }
 
 // runLoop serializes state updates and websocket writes on a single goroutine.
-func (m *PreviewServer) runLoop() {
-	var conn *websocket.Conn
-
-	lastRender := contracts.RenderMessage{Type: contracts.MessageTypeRender}
-	lastCursor := contracts.CursorMessage{Type: contracts.MessageTypeCursor}
-	haveCursor := false
-
-	for {
-		select {
-		case update := <-m.updates:
-			lastRender.Rev++
-			lastRender.HTML = update.html
-			lastRender.TOC = update.toc
-			lastRender.Filename = update.filename
+// Every session keeps its own render revision, cursor and TOC.
+func (m *PreviewServer) runLoop(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	sessions := make(map[string]*sessionState)
+	state := func(id string) *sessionState {
+		s, ok := sessions[id]
+		if !ok {
+			s = &sessionState{
+				clients:    make(map[*browserClient]struct{}),
+				lastRender: contracts.RenderMessage{Type: contracts.MessageTypeRender},
+				lastCursor: contracts.CursorMessage{Type: contracts.MessageTypeCursor},
+			}
+			sessions[id] = s
+		}
+		return s
+	}
 
-			if conn == nil {
-				continue
+	// broadcast queues msg for every browser of a session and drops the
+	// clients that cannot keep up.
+	broadcast := func(s *sessionState, msg any) {
+		for c := range s.clients {
+			if !c.enqueue(msg) {
+				delete(s.clients, c)
 			}
+		}
+	}
 
-			if !writeJSON(conn, lastRender) {
-				conn = nil
-				continue
+	// follow queues the presenter's msg for every other browser.
+	follow := func(s *sessionState, from *browserClient, msg any) {
+		for c := range s.clients {
+			if c != from && !c.enqueue(msg) {
+				delete(s.clients, c)
 			}
+		}
+	}
 
-			if haveCursor {
-				lastCursor.Rev = lastRender.Rev
-				if !writeJSON(conn, lastCursor) {
-					conn = nil
+	for {
+		select {
+		case update := <-m.updates:
+			s := state(update.session)
+			blocks, patch, incremental := diffBlocks(s, update.blocks)
+			baseRev := s.lastRender.Rev
+			s.blocks = blocks
+			s.lastRender.Rev++
+			s.lastRender.HTML = joinBlocks(blocks)
+			s.lastRender.TOC = update.toc
+			s.lastRender.Filename = update.filename
+
+			if incremental && baseRev > 0 {
+				patch.Type = contracts.MessageTypePatch
+				patch.BaseRev = baseRev
+				patch.Rev = s.lastRender.Rev
+				patch.TOC = update.toc
+				patch.Filename = update.filename
+				for c := range s.clients {
+					if !c.enqueueRender(s.lastRender, &patch) {
+						delete(s.clients, c)
+					}
 				}
+			} else {
+				broadcast(s, s.lastRender)
+			}
+			if s.haveCursor {
+				s.lastCursor.Rev = s.lastRender.Rev
+				broadcast(s, s.lastCursor)
 			}
 
 		case cursor := <-m.cursors:
-			lastCursor = cursor
-			haveCursor = true
+			s := state(cursor.session)
+			s.lastCursor = cursor.msg
+			s.haveCursor = true
 
-			if conn == nil || lastRender.Rev == 0 {
+			if s.lastRender.Rev == 0 {
 				continue
 			}
 
-			lastCursor.Rev = lastRender.Rev
-			if !writeJSON(conn, lastCursor) {
-				conn = nil
-			}
+			s.lastCursor.Rev = s.lastRender.Rev
+			broadcast(s, s.lastCursor)
 
 		case c := <-m.register:
-			if conn != nil {
-				_ = conn.Close()
-			}
-			conn = c
+			// Replay the latest state so a new or reconnecting tab catches up.
+			s := state(c.session)
+			s.clients[c] = struct{}{}
 
-			if !writeJSON(conn, lastRender) {
-				conn = nil
+			if !c.enqueue(s.lastRender) {
+				delete(s.clients, c)
 				continue
 			}
-
-			if haveCursor && lastRender.Rev > 0 {
-				lastCursor.Rev = lastRender.Rev
-				if !writeJSON(conn, lastCursor) {
-					conn = nil
+			if s.haveCursor && s.lastRender.Rev > 0 {
+				s.lastCursor.Rev = s.lastRender.Rev
+				if !c.enqueue(s.lastCursor) {
+					delete(s.clients, c)
+					continue
+				}
+			}
+			if s.lastScroll != nil || s.lastFold != nil {
+				viewport := contracts.ViewportMessage{
+					Type: contracts.MessageTypeViewport,
+					Fold: s.lastFold,
+				}
+				if s.lastScroll != nil {
+					scroll := *s.lastScroll
+					scroll.Rev = s.lastRender.Rev
+					viewport.Scroll = &scroll
+				}
+				if !c.enqueue(viewport) {
+					delete(s.clients, c)
 				}
 			}
 
+		case reply := <-m.inspect:
+			reply <- debugSessions(sessions)
+
 		case c := <-m.unregister:
-			if conn == c {
-				_ = conn.Close()
-				conn = nil
+			slog.Debug("browser disconnected", "session", c.session, "remote", c.addr)
+			c.close()
+			if s, ok := sessions[c.session]; ok {
+				delete(s.clients, c)
+			}
+
+		case id := <-m.closed:
+			s, ok := sessions[id]
+			if !ok {
+				continue
+			}
+			for c := range s.clients {
+				c.close()
+			}
+			delete(sessions, id)
+
+		case in := <-m.browserInbound:
+			s, ok := sessions[in.session]
+			if !ok {
+				continue
 			}
 
-		case raw := <-m.browserInbound:
 			var envelope contracts.IncomingMessage
-			if err := json.Unmarshal(raw, &envelope); err != nil {
+			if err := json.Unmarshal(in.raw, &envelope); err != nil {
+				slog.Debug("malformed browser message", "session", in.session, "err", err)
 				continue
 			}
+			if envelope.Type == contracts.MessageTypeResync {
+				// A patch did not apply; start the browser over.
+				if !in.client.enqueue(s.lastRender) {
+					delete(s.clients, in.client)
+				}
+				continue
+			}
+			if in.client.viewer {
+				// Viewers are read-only; they neither act on the editor
+				// nor present to other browsers.
+				continue
+			}
+			presenting := in.client == s.presenterClient()
 			switch envelope.Type {
 			case contracts.MessageTypeGoToLine:
 				var msg contracts.GoToLineMessage
-				if err := json.Unmarshal(raw, &msg); err != nil {
+				if err := json.Unmarshal(in.raw, &msg); err != nil {
 					continue
 				}
-				if m.OnGoToLine != nil {
-					m.OnGoToLine(msg)
+				if fn := m.OnGoToLine; fn != nil {
+					m.dispatch(func() { fn(in.session, msg) })
 				}
 			case contracts.MessageTypeToggleCheckbox:
 				var msg contracts.ToggleCheckboxMessage
-				if err := json.Unmarshal(raw, &msg); err != nil {
+				if err := json.Unmarshal(in.raw, &msg); err != nil {
+					continue
+				}
+				if msg.Rev != s.lastRender.Rev {
+					continue
+				}
+				if fn := m.OnToggleCheckbox; fn != nil {
+					m.dispatch(func() { fn(in.session, msg) })
+				}
+			case contracts.MessageTypeScroll:
+				if !presenting {
 					continue
 				}
-				if msg.Rev != lastRender.Rev {
+				var msg contracts.ScrollMessage
+				if err := json.Unmarshal(in.raw, &msg); err != nil {
 					continue
 				}
-				if m.OnToggleCheckbox != nil {
-					m.OnToggleCheckbox(msg)
+				if msg.Rev != s.lastRender.Rev {
+					continue
+				}
+				s.lastScroll = &msg
+				follow(s, in.client, msg)
+			case contracts.MessageTypeFold:
+				if !presenting {
+					continue
 				}
+				var msg contracts.FoldMessage
+				if err := json.Unmarshal(in.raw, &msg); err != nil {
+					continue
+				}
+				s.lastFold = &msg
+				follow(s, in.client, msg)
 			}
 
-		case <-m.stopLoop:
-			if conn != nil {
-				_ = conn.Close()
-				conn = nil
+		case <-stop:
+			for _, s := range sessions {
+				for c := range s.clients {
+					c.close()
+				}
 			}
+			m.drainQueues()
 			return
 		}
 	}
 }
 
-// writeJSON writes a JSON message and reports whether the connection is usable.
-func writeJSON(conn *websocket.Conn, v any) bool {
-	if err := conn.WriteJSON(v); err != nil {
-		_ = conn.Close()
-		return false
+// dispatch hands a browser action to the dispatcher without blocking.
+// Actions are dropped while the dispatcher is backed up behind an
+// unresponsive editor; the user can simply repeat the click.
+func (m *PreviewServer) dispatch(fn func()) {
+	select {
+	case m.actions <- fn:
+	default:
+	}
+}
+
+// dispatchLoop runs browser actions one at a time, in arrival order.
+func (m *PreviewServer) dispatchLoop(stop <-chan struct{}) {
+	for {
+		select {
+		case fn := <-m.actions:
+			fn()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// drainQueues discards queued messages so a restarted loop begins clean.
+func (m *PreviewServer) drainQueues() {
+	for {
+		select {
+		case <-m.updates:
+		case <-m.cursors:
+		case <-m.closed:
+		case <-m.browserInbound:
+		case <-m.actions:
+		default:
+			return
+		}
 	}
-	return true
 }
@@ -0,0 +1,241 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ShareConfig selects where shared sessions are served to other machines.
+type ShareConfig struct {
+	// Interface is a network interface name (e.g. "eth0") or an IP address
+	// to bind. Empty picks the first non-loopback IPv4 address.
+	Interface string
+	// Port is the preferred port. Zero reuses the preferred port and range
+	// of the local listener.
+	Port int
+}
+
+// Viewer is a read-only browser connected to a shared session.
+type Viewer struct {
+	Addr    string
+	Browser string
+	Since   time.Time
+}
+
+// SetShareConfig changes where sessions are shared. It takes effect the
+// next time the share listener starts.
+func (m *PreviewServer) SetShareConfig(cfg ShareConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.shareConfig = cfg
+}
+
+// Share grants read-only access to a session and returns the URL viewers
+// open. The share listener on the configured interface is started on first
+// use; it only accepts viewer tokens and never serves the index page.
+func (m *PreviewServer) Share(session string) (string, error) {
+	if !m.hasSession(session) {
+		return "", fmt.Errorf("session %s is not being previewed", session)
+	}
+
+	addr, err := m.startShare()
+	if err != nil {
+		return "", err
+	}
+
+	m.sessionsMu.Lock()
+	info, ok := m.sessions[session]
+	if !ok {
+		m.sessionsMu.Unlock()
+		return "", fmt.Errorf("session %s is not being previewed", session)
+	}
+	if info.viewerToken == "" {
+		info.viewerToken = NewToken()
+	}
+	token := info.viewerToken
+	m.sessionsMu.Unlock()
+
+	return "http://" + addr + sessionPath(session) + "?token=" + url.QueryEscape(token), nil
+}
+
+// Unshare revokes viewer access to a session and disconnects its viewers.
+func (m *PreviewServer) Unshare(session string) {
+	m.sessionsMu.Lock()
+	info, ok := m.sessions[session]
+	var viewers map[*browserClient]struct{}
+	if ok {
+		info.viewerToken = ""
+		viewers = info.viewers
+		info.viewers = nil
+	}
+	m.sessionsMu.Unlock()
+
+	for c := range viewers {
+		c.close()
+	}
+}
+
+// Viewers lists the read-only browsers connected to a session, oldest first.
+func (m *PreviewServer) Viewers(session string) []Viewer {
+	m.sessionsMu.Lock()
+	defer m.sessionsMu.Unlock()
+
+	info, ok := m.sessions[session]
+	if !ok {
+		return nil
+	}
+
+	out := make([]Viewer, 0, len(info.viewers))
+	for c := range info.viewers {
+		out = append(out, Viewer{Addr: c.addr, Browser: c.browser, Since: c.since})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Since.Before(out[j].Since) })
+	return out
+}
+
+// addViewer records a connected viewer of a session.
+func (m *PreviewServer) addViewer(c *browserClient) {
+	m.sessionsMu.Lock()
+	defer m.sessionsMu.Unlock()
+
+	info, ok := m.sessions[c.session]
+	if !ok {
+		return
+	}
+	if info.viewers == nil {
+		info.viewers = make(map[*browserClient]struct{})
+	}
+	info.viewers[c] = struct{}{}
+}
+
+// removeViewer forgets a disconnected viewer.
+func (m *PreviewServer) removeViewer(c *browserClient) {
+	m.sessionsMu.Lock()
+	defer m.sessionsMu.Unlock()
+
+	if info, ok := m.sessions[c.session]; ok {
+		delete(info.viewers, c)
+	}
+}
+
+// startShare starts the share listener unless it already runs and returns
+// its address.
+func (m *PreviewServer) startShare() (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.started {
+		return "", errors.New("preview server is not running")
+	}
+	if m.shareServer != nil {
+		return m.shareAddr, nil
+	}
+
+	host, err := shareHost(m.shareConfig.Interface)
+	if err != nil {
+		return "", err
+	}
+
+	cfg := ListenConfig{Host: host, Port: m.shareConfig.Port}
+	if cfg.Port == 0 {
+		cfg.Port = m.listen.Port
+		cfg.PortRange = m.listen.PortRange
+	}
+	listener, err := listen(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	handler := m.server.Handler
+	server := &http.Server{
+		Addr: listener.Addr().String(),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sharedKey{}, true)))
+		}),
+	}
+
+	m.shareServer = server
+	m.shareAddr = server.Addr
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("share listener stopped serving", "addr", server.Addr, "err", err)
+			_ = listener.Close()
+		}
+	}()
+	slog.Info("sharing sessions", "addr", m.shareAddr)
+	return m.shareAddr, nil
+}
+
+// shareHost resolves the configured interface to an IP address to bind.
+func shareHost(iface string) (string, error) {
+	if iface != "" {
+		if ip := net.ParseIP(iface); ip != nil {
+			return ip.String(), nil
+		}
+
+		ifi, err := net.InterfaceByName(iface)
+		if err != nil {
+			return "", fmt.Errorf("unknown share interface %q: %w", iface, err)
+		}
+		if ip, ok := interfaceIPv4(ifi); ok {
+			return ip, nil
+		}
+		return "", fmt.Errorf("share interface %q has no IPv4 address", iface)
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	for i := range ifaces {
+		ifi := &ifaces[i]
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if ip, ok := interfaceIPv4(ifi); ok {
+			return ip, nil
+		}
+	}
+	return "", errors.New("no network interface to share on; set share.interface")
+}
+
+func interfaceIPv4(ifi *net.Interface) (string, bool) {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return "", false
+	}
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ip4 := ipnet.IP.To4(); ip4 != nil && !ip4.IsLoopback() {
+			return ip4.String(), true
+		}
+	}
+	return "", false
+}
+
+// browserName summarizes a User-Agent header for the viewer list.
+func browserName(userAgent string) string {
+	switch {
+	case strings.Contains(userAgent, "Firefox/"):
+		return "Firefox"
+	case strings.Contains(userAgent, "Edg/"):
+		return "Edge"
+	case strings.Contains(userAgent, "Chrome/"):
+		return "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		return "Safari"
+	}
+	return "browser"
+}
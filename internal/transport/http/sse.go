@@ -0,0 +1,139 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// The Server-Sent Events transport serves browsers whose websockets are
+// broken, e.g. by a proxy. Messages to the browser stream from /events;
+// the browser posts its own messages to /send, naming the stream it was
+// handed in the initial "hello" event. Both ends feed the same runLoop as
+// websockets do.
+
+// sseConn is a Server-Sent Events stream to a browser. It is only written
+// from the handler goroutine that owns the response.
+type sseConn struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+// write sends data uncompressed; responses are not content-encoded.
+func (s *sseConn) write(data []byte) (bool, error) {
+	return false, s.send(fmt.Sprintf("data: %s\n\n", data))
+}
+
+func (s *sseConn) ping() error {
+	return s.send(": ping\n\n")
+}
+
+// send writes a raw event and flushes it to the browser.
+func (s *sseConn) send(event string) error {
+	if err := s.rc.SetWriteDeadline(time.Now().Add(writeWait)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	if _, err := io.WriteString(s.w, event); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// close is a no-op; the stream ends when its handler returns.
+func (s *sseConn) close() error {
+	return nil
+}
+
+// handleEvents streams the messages of a session as Server-Sent Events.
+func (m *PreviewServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	session := r.URL.Query().Get("doc")
+	if session == "" || !m.hasSession(session) {
+		http.NotFound(w, r)
+		return
+	}
+	role := m.sessionAccess(r, session)
+	if role == accessNone || !sameOrigin(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	stop, ok := m.running()
+	if !ok {
+		http.Error(w, "preview server is not running", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	conn := &sseConn{w: w, rc: http.NewResponseController(w)}
+	stream := NewToken()
+	if err := conn.send(fmt.Sprintf("event: hello\ndata: %s\n\n", stream)); err != nil {
+		return
+	}
+
+	client, disconnect, ok := m.connectClient(stop, r, session, role, conn)
+	if !ok {
+		return
+	}
+	defer disconnect()
+
+	m.sessionsMu.Lock()
+	m.streams[stream] = client
+	m.sessionsMu.Unlock()
+	defer func() {
+		m.sessionsMu.Lock()
+		delete(m.streams, stream)
+		m.sessionsMu.Unlock()
+	}()
+
+	// The browser going away cancels the request; end the write loop then.
+	defer context.AfterFunc(r.Context(), client.close)()
+	client.writeLoop()
+}
+
+// handleSend accepts a single browser message for an open event stream.
+func (m *PreviewServer) handleSend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	session := query.Get("doc")
+	role := m.sessionAccess(r, session)
+	if role == accessNone || !sameOrigin(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	m.sessionsMu.Lock()
+	client, ok := m.streams[query.Get("stream")]
+	m.sessionsMu.Unlock()
+	if !ok || client.session != session {
+		http.NotFound(w, r)
+		return
+	}
+	if role == accessViewer && !client.viewer {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	raw, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxInboundSize))
+	if err != nil {
+		http.Error(w, "message too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	stop, running := m.running()
+	if !running || !m.receive(stop, client, raw) {
+		http.Error(w, "preview server is not running", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
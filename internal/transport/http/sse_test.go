@@ -0,0 +1,98 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-live-markdown/internal/contracts"
+)
+
+// send requests /send with msg the way the page posts it for an event
+// stream, and returns the response status.
+func send(m *PreviewServer, method string, query string, origin string, msg string) int {
+	r := httptest.NewRequest(method, "/send?"+query, strings.NewReader(msg))
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	w := httptest.NewRecorder()
+	m.handleSend(w, r)
+	return w.Code
+}
+
+func TestHandleSendRejects(t *testing.T) {
+	m := newTestServer(t)
+	m.streams["editor"] = &browserClient{session: testSession}
+	m.streams["viewer"] = &browserClient{session: testSession, viewer: true}
+
+	goToLine := `{"type":"go_to_line","line":1}`
+	tests := []struct {
+		name   string
+		method string
+		query  string
+		origin string
+		want   int
+	}{
+		{"get", http.MethodGet, "doc=doc&stream=editor&token=" + testToken, "", http.StatusMethodNotAllowed},
+		{"no token", http.MethodPost, "doc=doc&stream=editor", "", http.StatusForbidden},
+		{"wrong token", http.MethodPost, "doc=doc&stream=editor&token=guess", "", http.StatusForbidden},
+		{"viewer token on the editor's stream", http.MethodPost, "doc=doc&stream=editor&token=" + testViewerToken, "", http.StatusForbidden},
+		{"cross-origin", http.MethodPost, "doc=doc&stream=editor&token=" + testToken, "http://evil.example", http.StatusForbidden},
+		{"unknown stream", http.MethodPost, "doc=doc&stream=guess&token=" + testToken, "", http.StatusNotFound},
+		{"server stopped", http.MethodPost, "doc=doc&stream=editor&token=" + testToken, "", http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := send(m, tt.method, tt.query, tt.origin, goToLine); got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleSendIgnoresViewers(t *testing.T) {
+	m := newTestServer(t)
+	lines := make(chan int, 2)
+	m.SetGoToLineHandler(func(session string, msg contracts.GoToLineMessage) {
+		lines <- msg.Line
+	})
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+
+	stop, _ := m.running()
+	connect := func(stream string, role access) {
+		r := httptest.NewRequest(http.MethodGet, "/events", nil)
+		client, _, ok := m.connectClient(stop, r, testSession, role, &fakeConn{})
+		if !ok {
+			t.Fatal("server stopped")
+		}
+		m.sessionsMu.Lock()
+		m.streams[stream] = client
+		m.sessionsMu.Unlock()
+	}
+	connect("editor", accessEditor)
+	connect("viewer", accessViewer)
+
+	// The run loop handles messages in order, so a viewer's message that
+	// got through would be dispatched before the editor's.
+	if code := send(m, http.MethodPost, "doc=doc&stream=viewer&token="+testViewerToken, "", `{"type":"go_to_line","line":1}`); code != http.StatusNoContent {
+		t.Fatalf("viewer post: status = %d, want %d", code, http.StatusNoContent)
+	}
+	if code := send(m, http.MethodPost, "doc=doc&stream=editor&token="+testToken, "", `{"type":"go_to_line","line":2}`); code != http.StatusNoContent {
+		t.Fatalf("editor post: status = %d, want %d", code, http.StatusNoContent)
+	}
+
+	select {
+	case line := <-lines:
+		if line != 2 {
+			t.Errorf("jumped to line %d posted by a viewer", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the editor's message was not dispatched")
+	}
+}
@@ -0,0 +1,92 @@
+-- :checkhealth go_live_markdown
+local M = {}
+
+local health = vim.health
+
+local function check_host_prog()
+    local prog = require("go_live_markdown").host_prog()
+    if prog == "" then
+        health.error("host binary not found", {
+            "Run ./build in the plugin directory",
+            "or set g:go_live_markdown_host_prog",
+        })
+        return false
+    end
+    if vim.fn.executable(prog) ~= 1 then
+        health.error("host binary is not executable: " .. prog)
+        return false
+    end
+    health.ok("host binary: " .. prog)
+    return true
+end
+
+local function check_registration()
+    if vim.fn.exists(":GoLiveMarkdownStart") ~= 2 or vim.fn.exists("*GoLiveMarkdownSelfCheck") ~= 1 then
+        health.error("remote host is not registered", {
+            "Make sure plugin/go_live_markdown.lua is sourced, e.g. that the plugin is not lazy-loaded away",
+        })
+        return false
+    end
+    health.ok("remote host registered")
+    return true
+end
+
+-- Compares the commit the host binary was built from with the plugin checkout.
+local function check_version(version)
+    local built = version.revision or ""
+    if built == "" then
+        health.info(string.format("host built with %s without version control information", version.go or "go"))
+        return
+    end
+
+    local head = vim.fn.systemlist({ "git", "-C", require("go_live_markdown").root(), "rev-parse", "HEAD" })
+    if vim.v.shell_error ~= 0 or head[1] == nil then
+        health.info("host built from " .. built:sub(1, 12))
+        return
+    end
+
+    if head[1] ~= built then
+        health.warn(
+            string.format("host built from %s, plugin checkout is at %s", built:sub(1, 12), head[1]:sub(1, 12)),
+            { "Run ./build to rebuild the host binary" }
+        )
+    elseif version.modified then
+        health.info("host built from " .. built:sub(1, 12) .. " with local changes")
+    else
+        health.ok("host built from the current checkout (" .. built:sub(1, 12) .. ")")
+    end
+end
+
+function M.check()
+    health.start("go-live-markdown")
+
+    if not check_host_prog() or not check_registration() then
+        return
+    end
+
+    local sample = require("go_live_markdown").root() .. "/markdown-test.md"
+    local ok, report = pcall(vim.fn.GoLiveMarkdownSelfCheck, { sample })
+    if not ok then
+        health.error("host did not answer: " .. tostring(report), {
+            "Set $NVIM_GO_LIVE_MARKDOWN_LOG_FILE and check the log",
+        })
+        return
+    end
+
+    check_version(report.version or {})
+
+    for _, check in ipairs(report.checks or {}) do
+        local msg = check.name .. ": " .. check.message
+        if check.status == "ok" then
+            health.ok(msg)
+        elseif check.status == "warn" then
+            health.warn(msg)
+        else
+            health.error(msg)
+        end
+    end
+
+    health.info("If the page stays blank, open /debug/state with the index token or run :GoLiveMarkdownDebug")
+end
+
+return M
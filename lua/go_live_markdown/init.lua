@@ -0,0 +1,119 @@
+local M = {}
+
+local defaults = {
+    -- Interface the preview server binds to.
+    host = "127.0.0.1",
+    -- Preferred port.
+    port = 7777,
+    -- Inclusive range probed when the preferred port is busy. If every port in
+    -- the range is taken, the host falls back to any free port.
+    port_range = { 7777, 7799 },
+    -- Share one long-lived preview server between all Neovim instances. The
+    -- first instance launches it; it exits when the last instance disconnects.
+    daemon = false,
+    -- Control socket of the shared daemon. Empty picks a per-user default.
+    daemon_socket = "",
+    -- Extra directories local images may be loaded from. The document's
+    -- directory and its project root are always allowed.
+    asset_roots = {},
+    -- Commands that draw fences of a language as SVG, e.g.
+    -- { dot = "dot -Tsvg", d2 = "d2 - -" }. The fence contents go to stdin
+    -- and the command runs in the document's directory.
+    fence_renderers = {},
+    -- Where :GoLiveMarkdownShare serves read-only viewers on the local
+    -- network. `interface` is an interface name or IP address; empty picks
+    -- the first non-loopback address. Port 0 reuses `port`/`port_range`.
+    share = {
+        interface = "",
+        port = 0,
+    },
+}
+
+M.config = vim.deepcopy(defaults)
+
+-- Override the defaults. Options apply the next time the preview server starts.
+function M.setup(opts)
+    M.config = vim.tbl_deep_extend("force", vim.deepcopy(defaults), opts or {})
+end
+
+-- Render durations and message sizes, e.g. for a statusline. Returns nil
+-- while the host is not running rather than starting it.
+function M.metrics()
+    if vim.fn["remote#host#IsRunning"]("go_live_markdown") ~= 1 then
+        return nil
+    end
+    local ok, metrics = pcall(vim.fn.GoLiveMarkdownMetrics)
+    if not ok then
+        return nil
+    end
+    return metrics
+end
+
+-- Preview state of a buffer (default: the current one), e.g. for a
+-- statusline: active, buffer, url, sessions, browsers, viewers, rev,
+-- render_ms and last_error. With opts.json it is returned as a JSON string
+-- for scripts. Returns an inactive state while the host is not running
+-- rather than starting it.
+function M.status(buf, opts)
+    local json = opts and opts.json
+    local inactive = {
+        active = false,
+        buffer = buf or vim.api.nvim_get_current_buf(),
+        url = "",
+        sessions = 0,
+        browsers = 0,
+        viewers = 0,
+        rev = 0,
+        render_ms = 0,
+        last_error = "",
+    }
+    if vim.fn["remote#host#IsRunning"]("go_live_markdown") ~= 1 then
+        return json and vim.json.encode(inactive) or inactive
+    end
+    local fn = json and vim.fn.GoLiveMarkdownStatusJSON or vim.fn.GoLiveMarkdownStatus
+    local ok, status = pcall(fn, buf or 0)
+    if not ok then
+        inactive.last_error = tostring(status)
+        return json and vim.json.encode(inactive) or inactive
+    end
+    return status
+end
+
+-- Root of the plugin checkout.
+function M.root()
+    local source = debug.getinfo(1, "S").source
+    local script_path = source:sub(1, 1) == "@" and source:sub(2) or source
+    return vim.fn.fnamemodify(script_path, ":p:h:h:h")
+end
+
+-- Resolve the Go host executable. Priority:
+-- 1) g:go_live_markdown_host_prog
+-- 2) local repo build in ./bin
+-- 3) executable found in PATH
+function M.host_prog()
+    if vim.g.go_live_markdown_host_prog ~= nil then
+        return vim.fn.expand(vim.g.go_live_markdown_host_prog, true)
+    end
+
+    local local_bin = M.root() .. "/bin/go-live-markdown-nvim"
+    if vim.fn.executable(local_bin) == 1 then
+        return local_bin
+    end
+
+    return vim.fn.exepath("go-live-markdown-nvim")
+end
+
+-- Evaluated by the Go host when a preview starts.
+function M.host_config()
+    local cfg = vim.deepcopy(M.config)
+    cfg.asset_roots = vim.tbl_map(function(root)
+        return vim.fn.fnamemodify(vim.fn.expand(root), ":p")
+    end, cfg.asset_roots or {})
+    -- An empty table would reach the host as a list.
+    if vim.tbl_isempty(cfg.fence_renderers or {}) then
+        cfg.fence_renderers = vim.empty_dict()
+    end
+    return cfg
+end
+
+return M
@@ -3,29 +3,9 @@ if vim.g.loaded_go_live_markdown then
 end
 vim.g.loaded_go_live_markdown = 1
 
--- Resolve the Go host executable. Priority:
--- 1) g:go_live_markdown_host_prog
--- 2) local repo build in ./bin
--- 3) executable found in PATH
-local function host_prog()
-    if vim.g.go_live_markdown_host_prog ~= nil then
-        return vim.fn.expand(vim.g.go_live_markdown_host_prog, true)
-    end
-
-    local source = debug.getinfo(1, "S").source
-    local script_path = source:sub(1, 1) == "@" and source:sub(2) or source
-    local repo_root = vim.fn.fnamemodify(script_path, ":h:h")
-    local local_bin = repo_root .. "/bin/go-live-markdown-nvim"
-    if vim.fn.executable(local_bin) == 1 then
-        return local_bin
-    end
-
-    return vim.fn.exepath("go-live-markdown-nvim")
-end
-
 -- Called by Neovim's remote-host registry to validate/start the plugin host.
 function _G.go_live_markdown_require_host(host)
-    local prog = host_prog()
+    local prog = require("go_live_markdown").host_prog()
     if prog == "" then
         vim.notify(
             "go-live-markdown host binary not found; run ./build or set g:go_live_markdown_host_prog",
@@ -46,40 +26,72 @@ endfunction]])
 vim.cmd([[call remote#host#Register('go_live_markdown', '*', function('GoLiveMarkdownRequireHost'))]])
 
 vim.cmd([[call remote#host#RegisterPlugin('go_live_markdown', '0', [
-\ {'type': 'command', 'name': 'GoLiveMarkdownStart', 'sync': 1, 'opts': {}},
-\ {'type': 'function', 'name': 'GoLiveMarkdownInternalUpdate', 'sync': 1, 'opts': {}},
-\ {'type': 'function', 'name': 'GoLiveMarkdownInternalCursor', 'sync': 1, 'opts': {}},
+\ {'type': 'command', 'name': 'GoLiveMarkdownStart', 'sync': 1, 'opts': {'eval': 'luaeval(''require("go_live_markdown").host_config()'')'}},
+\ {'type': 'command', 'name': 'GoLiveMarkdownStop', 'sync': 1, 'opts': {'bang': ''}},
+\ {'type': 'command', 'name': 'GoLiveMarkdownToggle', 'sync': 1, 'opts': {'eval': 'luaeval(''require("go_live_markdown").host_config()'')'}},
+\ {'type': 'command', 'name': 'GoLiveMarkdownShare', 'sync': 1, 'opts': {'bang': '', 'eval': 'luaeval(''require("go_live_markdown").host_config()'')'}},
+\ {'type': 'command', 'name': 'GoLiveMarkdownViewers', 'sync': 1, 'opts': {}},
+\ {'type': 'command', 'name': 'GoLiveMarkdownDebug', 'sync': 1, 'opts': {}},
+\ {'type': 'function', 'name': 'GoLiveMarkdownSelfCheck', 'sync': 1, 'opts': {'eval': 'luaeval(''require("go_live_markdown").host_config()'')'}},
+\ {'type': 'function', 'name': 'GoLiveMarkdownStatus', 'sync': 1, 'opts': {}},
+\ {'type': 'function', 'name': 'GoLiveMarkdownStatusJSON', 'sync': 1, 'opts': {}},
+\ {'type': 'function', 'name': 'GoLiveMarkdownMetrics', 'sync': 1, 'opts': {}},
+\ {'type': 'function', 'name': 'GoLiveMarkdownInternalUpdate', 'sync': 0, 'opts': {}},
+\ {'type': 'function', 'name': 'GoLiveMarkdownInternalCursor', 'sync': 0, 'opts': {}},
+\ {'type': 'function', 'name': 'GoLiveMarkdownInternalWipe', 'sync': 1, 'opts': {}},
+\ {'type': 'function', 'name': 'GoLiveMarkdownInternalShutdown', 'sync': 1, 'opts': {}},
 \ ])]])
 
 local group = vim.api.nvim_create_augroup("go_live_markdown_updates", { clear = true })
 
+-- Teardown hooks only matter once the host runs; avoid spawning it just to stop.
+local function host_running()
+    return vim.fn["remote#host#IsRunning"]("go_live_markdown") == 1
+end
+
 --------------------------------------------------------------------------------
 --------------------------- AUTOCOMMANDS ---------------------------------------
 --------------------------------------------------------------------------------
 
-vim.api.nvim_create_autocmd({ "TextChanged", "TextChangedI" }, {
+vim.api.nvim_create_autocmd({ "CursorMoved", "CursorMovedI" }, {
     group = group,
     pattern = "*.md",
-    callback = function()
-        -- Ignore RPC errors to avoid disrupting normal editing flow.
-        pcall(vim.api.nvim_call_function, "GoLiveMarkdownInternalUpdate", {})
+    callback = function(args)
+        -- Sent as a notification with the position, so the editor never waits.
+        local pos = vim.api.nvim_win_get_cursor(0)
+        pcall(vim.api.nvim_call_function, "GoLiveMarkdownInternalCursor", { args.buf, pos[1], pos[2] + 1 })
     end,
 })
 
-vim.api.nvim_create_autocmd({ "CursorMoved", "CursorMovedI" }, {
+vim.api.nvim_create_autocmd({ "BufEnter" }, {
     group = group,
     pattern = "*.md",
-    callback = function()
-        -- Ignore RPC errors to avoid disrupting normal editing flow.
-        pcall(vim.api.nvim_call_function, "GoLiveMarkdownInternalCursor", {})
+    callback = function(args)
+        -- Edits reach the host as buffer events; on focus it only checks for
+        -- missed changes and re-attaches when the buffer was reloaded.
+        pcall(vim.api.nvim_call_function, "GoLiveMarkdownInternalUpdate", { args.buf })
     end,
 })
 
-vim.api.nvim_create_autocmd({ "BufEnter" }, {
+vim.api.nvim_create_autocmd({ "BufWipeout" }, {
     group = group,
     pattern = "*.md",
+    callback = function(args)
+        if not host_running() then
+            return
+        end
+        -- Drop the wiped buffer's session; the server stops with the last one.
+        pcall(vim.api.nvim_call_function, "GoLiveMarkdownInternalWipe", { args.buf })
+    end,
+})
+
+vim.api.nvim_create_autocmd({ "VimLeavePre" }, {
+    group = group,
     callback = function()
-        -- Keep preview in sync when markdown buffers gain/lose focus.
-        pcall(vim.api.nvim_call_function, "GoLiveMarkdownInternalUpdate", {})
+        if not host_running() then
+            return
+        end
+        -- Close browser connections and free the port before Neovim exits.
+        pcall(vim.api.nvim_call_function, "GoLiveMarkdownInternalShutdown", {})
     end,
 })